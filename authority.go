@@ -3,23 +3,91 @@ package href
 import (
 	"fmt"
 	"net"
+	"net/url"
+	"strings"
+	"unicode/utf8"
 )
 
 type (
 	Authority struct {
-		Host   Host
-		Port   Port
-		IsNull bool // no authority, leading slash
-		IsTrue bool // no authority, no slash
+		Userinfo Userinfo
+		Host     Host
+		Port     Port
+		IsNull   bool // no authority, leading slash
+		IsTrue   bool // no authority, no slash
+	}
+	Userinfo struct {
+		val *string
 	}
 	Host struct {
-		val interface{}
+		val  interface{}
+		zone string
 	}
 	Port struct {
 		val *uint64
 	}
 )
 
+func (o *Userinfo) Set(v interface{}) error {
+	if s, ok := v.(string); ok {
+		o.val = &s
+		return nil
+	}
+
+	return fmt.Errorf("unexpected userinfo type: %T", v)
+}
+
+func (o Userinfo) IsSet() bool {
+	return o.val != nil
+}
+
+func (o Userinfo) Get() string {
+	if o.IsSet() {
+		return *o.val
+	}
+	return ""
+}
+
+func (o Userinfo) clone() Userinfo {
+	if !o.IsSet() {
+		return Userinfo{}
+	}
+	s := *o.val
+	return Userinfo{val: &s}
+}
+
+func (o *Userinfo) Reset() {
+	o.val = nil
+}
+
+// String returns the percent-encoded userinfo followed by "@", or the empty
+// string if userinfo is not set
+func (o Userinfo) String() string {
+	if !o.IsSet() {
+		return ""
+	}
+	return pctEncode(*o.val, isUserinfoChar) + "@"
+}
+
+// urlUserinfo returns the userinfo in the form expected by the User field of
+// url.URL.  If url.URL would not encode the userinfo as mandated by §6.1,
+// false is returned.
+func (o Userinfo) urlUserinfo() (*url.Userinfo, bool) {
+	if !o.IsSet() {
+		return nil, true
+	}
+
+	var u *url.Userinfo
+
+	if i := strings.IndexByte(*o.val, ':'); i != -1 {
+		u = url.UserPassword((*o.val)[:i], (*o.val)[i+1:])
+	} else {
+		u = url.User(*o.val)
+	}
+
+	return u, u.String()+"@" == o.String()
+}
+
 func (o *Port) Set(v interface{}) error {
 	var (
 		ok bool
@@ -31,7 +99,7 @@ func (o *Port) Set(v interface{}) error {
 	}
 
 	if p > 65535 {
-		return fmt.Errorf("port number must be in range 0..65535: got %d", p)
+		return fmt.Errorf("%w: got %d", ErrPortRange, p)
 	}
 
 	o.val = &p
@@ -39,6 +107,14 @@ func (o *Port) Set(v interface{}) error {
 	return nil
 }
 
+func (o Port) clone() Port {
+	if !o.IsSet() {
+		return Port{}
+	}
+	p := *o.val
+	return Port{val: &p}
+}
+
 func (o Port) IsSet() bool {
 	return o.val != nil
 }
@@ -61,15 +137,13 @@ func (o *Host) Set(v interface{}) error {
 	switch t := v.(type) {
 	case string:
 		// host-name
-		o.val = t
-		return nil
+		o.SetName(t)
+	case []string:
+		// host-name as an array of labels
+		return o.SetLabels(t)
 	case []byte:
 		// host-ip
-		l := len(t)
-		if l != 4 && l != 16 {
-			return fmt.Errorf("host-ip must be 4 or 16 bytes, got %d", l)
-		}
-		o.val = net.IP(t)
+		return o.SetIP(t)
 	default:
 		return fmt.Errorf("unknown host type: %T", t)
 	}
@@ -77,6 +151,120 @@ func (o *Host) Set(v interface{}) error {
 	return nil
 }
 
+// SetName sets a host-name in its dotted form
+func (o *Host) SetName(name string) {
+	o.val = name
+	o.zone = ""
+}
+
+// SetIP sets a host-ip, which must be 4 (IPv4) or 16 (IPv6) bytes long.  Note
+// that net.ParseIP returns IPv4 addresses in their 16 bytes form: use To4 to
+// convert them.
+func (o *Host) SetIP(ip net.IP) error {
+	l := len(ip)
+	if l != net.IPv4len && l != net.IPv6len {
+		return fmt.Errorf("host-ip must be 4 or 16 bytes, got %d", l)
+	}
+
+	o.val = append(net.IP{}, ip...)
+	o.zone = ""
+
+	return nil
+}
+
+// IP returns the host-ip, if the host is set as such
+func (o Host) IP() (net.IP, bool) {
+	ip, ok := o.val.(net.IP)
+	if !ok {
+		return nil, false
+	}
+	return append(net.IP{}, ip...), true
+}
+
+// Name returns the host-name in its dotted form, if the host is set as such
+func (o Host) Name() (string, bool) {
+	switch t := o.val.(type) {
+	case string:
+		return t, true
+	case []string:
+		return strings.Join(t, "."), true
+	}
+	return "", false
+}
+
+// SetLabels sets a host-name from its labels:
+//
+//	host-name = (+text)
+//
+// Unlike the single text form of href-09, a label may contain a dot.
+func (o *Host) SetLabels(labels []string) error {
+	if len(labels) == 0 {
+		return fmt.Errorf("host-name must have at least one label")
+	}
+
+	o.val = append([]string(nil), labels...)
+	o.zone = ""
+
+	return nil
+}
+
+// Labels returns the labels of a host-name, or nil if the host is not a
+// host-name.  A host-name in the single text form is split at the dots.
+func (o Host) Labels() []string {
+	switch t := o.val.(type) {
+	case string:
+		return strings.Split(t, ".")
+	case []string:
+		return append([]string(nil), t...)
+	default:
+		return nil
+	}
+}
+
+// SetZone sets the zone-id of an IPv6 host-ip:
+//
+//	host-ip = (bytes .size 4 // (bytes .size 16, ?zone-id))
+//	zone-id = text
+func (o *Host) SetZone(zone string) error {
+	ip, ok := o.val.(net.IP)
+	if !ok || len(ip) != net.IPv6len {
+		return fmt.Errorf("zone-id requires an IPv6 host-ip")
+	}
+
+	if zone == "" {
+		return fmt.Errorf("zone-id cannot be empty")
+	}
+
+	o.zone = zone
+
+	return nil
+}
+
+// Zone returns the zone-id of an IPv6 host-ip, or the empty string if there is
+// none
+func (o Host) Zone() string {
+	return o.zone
+}
+
+func (o Host) HasZone() bool {
+	return o.zone != ""
+}
+
+func (o Host) clone() Host {
+	c := Host{zone: o.zone}
+
+	switch t := o.val.(type) {
+	case []string:
+		c.val = append([]string{}, t...)
+	case net.IP:
+		c.val = append(net.IP{}, t...)
+	default:
+		c.val = o.val
+	}
+
+	return c
+}
+
 func (o Host) IsSet() bool {
 	return o.val != nil
 }
@@ -88,54 +276,193 @@ func (o Host) Get() interface{} {
 func (o Host) String() string {
 	switch t := o.val.(type) {
 	case string:
-		// TODO(tho) escape hostname
 		// href-06, §6.1:
 		// The "host-name" is turned into a single string by joining the
 		// elements separated by dots (".").  Any character in the value of a
 		// "host-name" item that is not in the set of unreserved characters
 		// (Section 2.3 of [RFC3986]) or "sub-delims" (Section 2.2 of
 		// [RFC3986]) MUST be percent-encoded.
-		return t
+		return pctEncode(t, isHostChar)
+	case []string:
+		// Any dot inside a label is percent-encoded, so that it is not
+		// mistaken for a label separator.
+		labels := make([]string, 0, len(t))
+		for _, l := range t {
+			labels = append(labels, pctEncode(l, isLabelChar))
+		}
+		return strings.Join(labels, ".")
 	case net.IP:
-		return t.String()
+		if len(t) == net.IPv4len {
+			return t.String()
+		}
+		// IP-literal = "[" ( IPv6address / IPv6addrz ) "]"
+		// IPv6addrz  = IPv6address "%25" ZoneID (RFC6874)
+		s := "[" + ipv6String(t)
+		if o.zone != "" {
+			s += "%25" + pctEncode(o.zone, isUnreserved)
+		}
+		return s + "]"
 	default:
 		return ""
 	}
 }
 
+// ipv6String formats a 16-byte IP in IPv6 notation.  Unlike net.IP.String, it
+// does not turn an IPv4-mapped address into its dotted decimal form.
+func ipv6String(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return "::ffff:" + ip4.String()
+	}
+	return ip.String()
+}
+
+// toCBOR returns the host items of the authority array: either the host-name
+// (one text or its labels, depending on v), or the host-ip followed by its
+// optional zone-id
+func (o Host) toCBOR(v Version) ([]interface{}, error) {
+	switch t := o.val.(type) {
+	case string:
+		if v == Href09 {
+			return []interface{}{t}, nil
+		}
+		return stringsToItems(strings.Split(t, ".")), nil
+	case []string:
+		if v == Href09 {
+			for _, l := range t {
+				if strings.Contains(l, ".") {
+					return nil, fmt.Errorf("host-name label %q contains a dot, not supported in %s", l, v)
+				}
+			}
+			return []interface{}{strings.Join(t, ".")}, nil
+		}
+		return stringsToItems(t), nil
+	case net.IP:
+		if o.HasZone() {
+			if v == Href09 {
+				return nil, fmt.Errorf("zone-id not supported in %s", v)
+			}
+			return []interface{}{t, o.zone}, nil
+		}
+	}
+
+	return []interface{}{o.val}, nil
+}
+
+func stringsToItems(s []string) []interface{} {
+	items := make([]interface{}, 0, len(s))
+	for _, e := range s {
+		items = append(items, e)
+	}
+	return items
+}
+
+// toCBOR returns the authority array encoded according to v
+func (o Authority) toCBOR(v Version) ([]interface{}, error) {
+	var authority []interface{}
+
+	if o.Userinfo.IsSet() {
+		if v == Href09 {
+			return nil, fmt.Errorf("userinfo not supported in %s", v)
+		}
+		authority = append(authority, false, o.Userinfo.Get())
+	}
+
+	host, err := o.Host.toCBOR(v)
+	if err != nil {
+		return nil, err
+	}
+
+	authority = append(authority, host...)
+
+	if o.Port.IsSet() {
+		authority = append(authority, o.Port.Get())
+	}
+
+	return authority, nil
+}
+
 func (o Authority) String() string {
 	if o.IsNull || o.IsTrue || o.Host.String() == "" {
 		return ""
 	}
 
-	return fmt.Sprintf("%s%s", o.Host, o.Port)
+	return fmt.Sprintf("%s%s%s", o.Userinfo, o.Host, o.Port)
+}
+
+// urlHost returns the authority in the (unescaped) form expected by the Host
+// field of url.URL, which percent-encodes it when the URL is serialized.  If
+// url.URL would not encode the host as mandated by §6.1, false is returned.
+func (o Authority) urlHost() (string, bool) {
+	if o.IsNull || o.IsTrue || !o.Host.IsSet() {
+		return "", true
+	}
+
+	var host string
+
+	switch t := o.Host.Get().(type) {
+	case string:
+		// url.URL does not percent-encode these
+		if strings.ContainsAny(t, ":[]<>\"") {
+			return "", false
+		}
+		host = t
+	case []string:
+		for _, l := range t {
+			if strings.ContainsAny(l, ".:[]<>\"") {
+				return "", false
+			}
+		}
+		host = strings.Join(t, ".")
+	case net.IP:
+		if len(t) == net.IPv4len {
+			host = t.String()
+			break
+		}
+		host = "[" + ipv6String(t)
+		if o.Host.HasZone() {
+			if pctEncode(o.Host.Zone(), isUnreserved) != o.Host.Zone() {
+				return "", false
+			}
+			host += "%" + o.Host.Zone()
+		}
+		host += "]"
+	}
+
+	// url.URL omits the "//" of an empty host-name without port
+	if host == "" && !o.Port.IsSet() {
+		return "", false
+	}
+
+	return host + o.Port.String(), true
 }
 
+// Set sets the authority from its CBOR decoded form: null, true, or an
+// authority array as taken by SetHostPort
 func (o *Authority) Set(val interface{}) error {
-	switch t := val.(type) {
-	case []interface{}:
-		if err := o.SetHostPort(t); err != nil {
-			return err
-		}
-	case nil:
-		o.SetNull()
-	case bool:
-		if !t {
-			return fmt.Errorf("unexpected authority type: false")
-		}
-		o.SetTrue()
-	default:
-		return fmt.Errorf("unexpected authority type: %T", val)
+	d, h, err := decodeValue(val)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return d.authority(o, h)
+}
+
+func (o Authority) clone() Authority {
+	return Authority{
+		Userinfo: o.Userinfo.clone(),
+		Host:     o.Host.clone(),
+		Port:     o.Port.clone(),
+		IsNull:   o.IsNull,
+		IsTrue:   o.IsTrue,
+	}
 }
 
 func (o *Authority) SetNull() {
 	o.IsNull = true
 
 	o.IsTrue = false
-	o.Host.val = nil
+	o.Userinfo.Reset()
+	o.Host = Host{}
 	o.Port.val = nil
 }
 
@@ -143,27 +470,88 @@ func (o *Authority) SetTrue() {
 	o.IsTrue = true
 
 	o.IsNull = false
-	o.Host.val = nil
+	o.Userinfo.Reset()
+	o.Host = Host{}
 	o.Port.val = nil
 }
 
+// SetHostPort sets the authority from the elements of an authority array,
+// according to the rules of the latest version:
+//
+//	authority = [?userinfo, host, ?port]
+//	userinfo  = (false, text)
+//	host      = host-name / host-ip
+//	host-name = (+text)
+//	host-ip   = (bytes .size 4 // (bytes .size 16, ?zone-id))
+//
+// In href-09 the authority is [host, ?port], where host-name is a single text.
+// As in Host.Set, and unlike Parse, a host-name made of a single text is in its
+// dotted form (e.g. "example.com"), so that the href-09 form keeps its meaning:
+// use Host.SetLabels for a single label that contains a dot.
 func (o *Authority) SetHostPort(val []interface{}) error {
-	switch len(val) {
-	case 2:
-		// host + port
-		if err := o.Port.Set(val[1]); err != nil {
-			return err
-		}
-		fallthrough
-	case 1:
-		// host
-		if err := o.Host.Set(val[0]); err != nil {
-			return err
-		}
-		o.IsTrue = false
-		o.IsNull = false
+	d, h, err := decodeValue(val)
+	if err != nil {
+		return err
+	}
+
+	return d.hostPort(o, h)
+}
+
+func (o Authority) validate() error {
+	if o.IsNull || o.IsTrue {
+		if o.IsNull && o.IsTrue {
+			return fmt.Errorf("authority cannot be both null and true")
+		}
+		if o.Host.IsSet() || o.Port.IsSet() || o.Userinfo.IsSet() {
+			return fmt.Errorf("a null or true authority cannot have host, port or userinfo")
+		}
+		return nil
+	}
+
+	if !o.Host.IsSet() {
+		if o.Port.IsSet() || o.Userinfo.IsSet() {
+			return fmt.Errorf("port and userinfo require a host")
+		}
+		return nil
+	}
+
+	switch t := o.Host.val.(type) {
+	case string:
+		if !utf8.ValidString(t) {
+			return fmt.Errorf("invalid UTF-8 in host-name %q", t)
+		}
+	case []string:
+		if len(t) == 0 {
+			return fmt.Errorf("host-name must have at least one label")
+		}
+		for _, l := range t {
+			if !utf8.ValidString(l) {
+				return fmt.Errorf("invalid UTF-8 in host-name label %q", l)
+			}
+		}
+	case net.IP:
+		if l := len(t); l != net.IPv4len && l != net.IPv6len {
+			return fmt.Errorf("host-ip must be 4 or 16 bytes, got %d", l)
+		}
 	default:
-		return fmt.Errorf("wrong number of elements in authority: %d", len(val))
+		return fmt.Errorf("unknown host type: %T", t)
+	}
+
+	if ip, ok := o.Host.val.(net.IP); o.Host.HasZone() && (!ok || len(ip) != net.IPv6len) {
+		return fmt.Errorf("zone-id requires an IPv6 host-ip")
+	}
+
+	// unlike path, query and fragment, these have no PET form
+	if !utf8.ValidString(o.Host.zone) {
+		return fmt.Errorf("invalid UTF-8 in zone-id %q", o.Host.zone)
+	}
+
+	if !utf8.ValidString(o.Userinfo.Get()) {
+		return fmt.Errorf("invalid UTF-8 in userinfo %q", o.Userinfo.Get())
+	}
+
+	if o.Port.IsSet() && o.Port.Get() > 65535 {
+		return fmt.Errorf("%w: got %d", ErrPortRange, o.Port.Get())
 	}
 
 	return nil
@@ -0,0 +1,70 @@
+package href
+
+import (
+	"testing"
+)
+
+var BenchVectors = []struct {
+	name string
+	cri  []byte
+}{
+	{
+		name: "relative",
+		// echo '[3]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8103"),
+	},
+	{
+		name: "ipv4",
+		// echo "[-2, [h'c0a80061']]" | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("82218144c0a80061"),
+	},
+	{
+		name: "host_port_path",
+		// echo '["coap+tcp", ["acme", "example", 5683], ["a", "b", "c"]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8368636f61702b746370836461636d65676578616d706c6519163383616161626163"),
+	},
+	{
+		name: "full",
+		// echo '[-1, [false, "user", "acme", "example", 5683], ["sensors", "temp"], ["unit=C", "rt=temp"], "frag"]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("852085f464757365726461636d65676578616d706c65191633826773656e736f72736474656d708266756e69743d436772743d74656d706466726167"),
+	},
+	{
+		name: "pet",
+		// echo "[-1, [\"acme\", \"example\"], [[\"caf\", h'e9'], \"b\"]]" | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8320826461636d65676578616d706c6582826363616641e96162"),
+	},
+}
+
+// BenchmarkParse measures Parse on the BenchVectors.  scripts/bench-parse.sh
+// compares it with a baseline revision given as argument.
+func BenchmarkParse(b *testing.B) {
+	for _, bv := range BenchVectors {
+		bv := bv
+		b.Run(bv.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Parse(bv.cri); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkToCBOR(b *testing.B) {
+	for _, bv := range BenchVectors {
+		bv := bv
+		c, err := Parse(bv.cri)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(bv.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.ToCBOR(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
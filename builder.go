@@ -0,0 +1,230 @@
+package href
+
+import (
+	"fmt"
+	"net"
+)
+
+// Builder constructs a CRI reference one section at a time, e.g.:
+//
+//	cri, err := href.NewCRI().
+//		Scheme("coaps").Host("example.com").Port(5684).
+//		Path("sensors", "temp").Query("u=C").
+//		Build()
+//
+// Each step is validated; the first failure is reported by Build.
+type Builder struct {
+	cri      CRI
+	relative bool
+	err      error
+}
+
+// NewCRI returns a Builder for an absolute CRI.  If no host is set, the
+// authority is null (the path has a leading slash) unless Rootless is called.
+func NewCRI() *Builder {
+	return &Builder{}
+}
+
+// NewReference returns a Builder for a relative CRI reference.  Unless a host
+// is set, in which case a network-path reference is built, the discard
+// defaults to 1 if there is a path (relative-path reference, e.g. "a/b"), and
+// to 0 otherwise (e.g. "?q").
+func NewReference() *Builder {
+	return &Builder{relative: true}
+}
+
+func (b *Builder) fail(step string, err error) *Builder {
+	if b.err == nil {
+		b.err = fmt.Errorf("%s: %w", step, err)
+	}
+	return b
+}
+
+func (b *Builder) absoluteOnly(step string) bool {
+	if b.relative {
+		b.fail(step, fmt.Errorf("not allowed in a relative reference"))
+		return false
+	}
+	return true
+}
+
+// Scheme sets the scheme-name
+func (b *Builder) Scheme(name string) *Builder {
+	if !b.absoluteOnly("Scheme") {
+		return b
+	}
+	if err := b.cri.Scheme.SetName(name); err != nil {
+		return b.fail("Scheme", err)
+	}
+	return b
+}
+
+// SchemeID sets the scheme-id
+func (b *Builder) SchemeID(id int64) *Builder {
+	if !b.absoluteOnly("SchemeID") {
+		return b
+	}
+	if err := b.cri.Scheme.SetID(id); err != nil {
+		return b.fail("SchemeID", err)
+	}
+	return b
+}
+
+// Rootless sets the authority to true, i.e., no authority and a path without
+// leading slash (e.g., "urn:ietf:rfc:7252")
+func (b *Builder) Rootless() *Builder {
+	if !b.absoluteOnly("Rootless") {
+		return b
+	}
+	if b.cri.Authority.Host.IsSet() {
+		return b.fail("Rootless", fmt.Errorf("host already set"))
+	}
+	b.cri.Authority.SetTrue()
+	return b
+}
+
+func (b *Builder) setHost(step string, set func(*Host) error) *Builder {
+	if b.cri.Authority.IsTrue {
+		return b.fail(step, fmt.Errorf("rootless CRI cannot have a host"))
+	}
+	if err := set(&b.cri.Authority.Host); err != nil {
+		return b.fail(step, err)
+	}
+	return b
+}
+
+// Host sets the host-name, which cannot be empty
+func (b *Builder) Host(name string) *Builder {
+	return b.setHost("Host", func(h *Host) error {
+		if name == "" {
+			return fmt.Errorf("host-name cannot be empty")
+		}
+		h.SetName(name)
+		return nil
+	})
+}
+
+// HostLabels sets the host-name from its labels, which cannot be a single
+// empty label
+func (b *Builder) HostLabels(labels ...string) *Builder {
+	return b.setHost("HostLabels", func(h *Host) error {
+		if len(labels) == 1 && labels[0] == "" {
+			return fmt.Errorf("host-name cannot be empty")
+		}
+		return h.SetLabels(labels)
+	})
+}
+
+// HostIP sets the host-ip
+func (b *Builder) HostIP(ip net.IP) *Builder {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return b.setHost("HostIP", func(h *Host) error {
+		return h.SetIP(ip)
+	})
+}
+
+// Zone sets the zone-id of an IPv6 host-ip
+func (b *Builder) Zone(zone string) *Builder {
+	if err := b.cri.Authority.Host.SetZone(zone); err != nil {
+		return b.fail("Zone", err)
+	}
+	return b
+}
+
+// Port sets the port
+func (b *Builder) Port(port uint16) *Builder {
+	_ = b.cri.Authority.Port.Set(uint64(port))
+	return b
+}
+
+// Userinfo sets the userinfo
+func (b *Builder) Userinfo(userinfo string) *Builder {
+	_ = b.cri.Authority.Userinfo.Set(userinfo)
+	return b
+}
+
+// Discard sets the number of trailing path segments of the base to discard
+func (b *Builder) Discard(n uint8) *Builder {
+	if !b.relative {
+		return b.fail("Discard", fmt.Errorf("not allowed in an absolute CRI"))
+	}
+	if err := b.cri.Discard.SetCount(uint64(n)); err != nil {
+		return b.fail("Discard", err)
+	}
+	return b
+}
+
+// DiscardAll discards the whole path of the base (absolute-path reference)
+func (b *Builder) DiscardAll() *Builder {
+	if !b.relative {
+		return b.fail("DiscardAll", fmt.Errorf("not allowed in an absolute CRI"))
+	}
+	b.cri.Discard.SetAll()
+	return b
+}
+
+// Path appends path segments
+func (b *Builder) Path(segments ...string) *Builder {
+	b.cri.Path.Append(segments)
+	return b
+}
+
+// Query appends query items
+func (b *Builder) Query(items ...string) *Builder {
+	b.cri.Query.Append(items)
+	return b
+}
+
+// Fragment sets the fragment
+func (b *Builder) Fragment(fragment string) *Builder {
+	b.cri.Fragment.setValue(fragment)
+	return b
+}
+
+// Build returns the CRI reference, or the first error encountered while
+// building it
+func (b *Builder) Build() (*CRI, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	c := b.cri.Clone()
+	a := &c.Authority
+
+	if !a.Host.IsSet() {
+		if a.Port.IsSet() || a.Userinfo.IsSet() {
+			return nil, fmt.Errorf("Build: port and userinfo require a host")
+		}
+		if !a.IsTrue {
+			a.SetNull()
+		}
+	}
+
+	if b.relative {
+		if a.Host.IsSet() && c.Discard.IsSet() {
+			return nil, fmt.Errorf("Build: discard cannot be combined with a host")
+		}
+		if !a.Host.IsSet() {
+			a.IsNull = false
+			if !c.Discard.IsSet() {
+				// a path replaces the last segment of the base, while the
+				// discard 0 cannot be followed by a path
+				if c.Path.IsSet() {
+					_ = c.Discard.SetCount(1)
+				} else {
+					_ = c.Discard.SetCount(0)
+				}
+			}
+		}
+	} else if !c.Scheme.IsSet() {
+		return nil, fmt.Errorf("Build: missing scheme")
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("Build: %w", err)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,315 @@
+package href
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// CBOR major types
+const (
+	majorUint   byte = 0
+	majorNint   byte = 1
+	majorBytes  byte = 2
+	majorText   byte = 3
+	majorArray  byte = 4
+	majorMap    byte = 5
+	majorTag    byte = 6
+	majorSimple byte = 7
+)
+
+// additional information values with a special meaning
+const (
+	aiUint8      byte = 24
+	aiUint16     byte = 25
+	aiUint32     byte = 26
+	aiUint64     byte = 27
+	aiIndefinite byte = 31
+)
+
+// simple values (major type 7) and the "break" stop code
+const (
+	simpleFalse     = 20
+	simpleTrue      = 21
+	simpleNull      = 22
+	simpleUndefined = 23
+
+	breakCode byte = 0xff
+)
+
+var errTruncated = errors.New("truncated CBOR data item")
+
+// cborHead is the head of a CBOR data item (RFC 8949, §3)
+type cborHead struct {
+	major byte
+	ai    byte
+	// arg is the argument: the value of an integer, the length of a string,
+	// the number of elements of an array or map, a tag number, a simple value
+	// or the bits of a float
+	arg uint64
+	// size is the number of bytes in the head
+	size int
+}
+
+// readHead reads the head of the CBOR data item at the start of data
+func readHead(data []byte) (cborHead, error) {
+	if len(data) == 0 {
+		return cborHead{}, errTruncated
+	}
+
+	h := cborHead{
+		major: data[0] >> 5,
+		ai:    data[0] & 0x1f,
+		size:  1,
+	}
+
+	var n int
+
+	switch {
+	case h.ai < aiUint8:
+		h.arg = uint64(h.ai)
+		return h, nil
+	case h.ai == aiUint8:
+		n = 1
+	case h.ai == aiUint16:
+		n = 2
+	case h.ai == aiUint32:
+		n = 4
+	case h.ai == aiUint64:
+		n = 8
+	case h.ai == aiIndefinite:
+		if h.major == majorUint || h.major == majorNint || h.major == majorTag {
+			return cborHead{}, fmt.Errorf("malformed CBOR: indefinite length in major type %d", h.major)
+		}
+		return h, nil
+	default:
+		return cborHead{}, fmt.Errorf("malformed CBOR: reserved additional information %d", h.ai)
+	}
+
+	if len(data) < 1+n {
+		return cborHead{}, errTruncated
+	}
+
+	for _, b := range data[1 : 1+n] {
+		h.arg = h.arg<<8 | uint64(b)
+	}
+
+	h.size += n
+
+	return h, nil
+}
+
+func (o cborHead) isIndefinite() bool {
+	return o.ai == aiIndefinite
+}
+
+func (o cborHead) isFloat() bool {
+	return o.major == majorSimple && o.ai >= aiUint16 && o.ai <= aiUint64
+}
+
+func (o cborHead) isSimple(v uint64) bool {
+	return o.major == majorSimple && o.ai < aiUint16 && o.arg == v
+}
+
+// typeName returns the CBOR type of the data item, for use in error messages
+func (o cborHead) typeName() string {
+	switch o.major {
+	case majorUint:
+		return "unsigned integer"
+	case majorNint:
+		return "negative integer"
+	case majorBytes:
+		return "byte string"
+	case majorText:
+		return "text string"
+	case majorArray:
+		return "array"
+	case majorMap:
+		return "map"
+	case majorTag:
+		return "tag"
+	}
+
+	switch {
+	case o.isFloat():
+		return "float"
+	case o.isSimple(simpleFalse):
+		return "false"
+	case o.isSimple(simpleTrue):
+		return "true"
+	case o.isSimple(simpleNull):
+		return "null"
+	case o.isSimple(simpleUndefined):
+		return "undefined"
+	}
+
+	return "simple value"
+}
+
+// isPreferred tells whether the argument is encoded in the shortest form
+// (RFC 8949, §4.2.1).  Floats are checked by isPreferredFloat.
+func (o cborHead) isPreferred() bool {
+	switch o.ai {
+	case aiUint8:
+		return o.arg >= 24
+	case aiUint16:
+		return o.arg > 0xff
+	case aiUint32:
+		return o.arg > 0xffff
+	case aiUint64:
+		return o.arg > 0xffffffff
+	}
+	return true
+}
+
+// isPreferredFloat tells whether a float is encoded in the shortest form that
+// preserves its value
+func (o cborHead) isPreferredFloat() bool {
+	switch o.ai {
+	case aiUint32:
+		return !float32FitsFloat16(uint32(o.arg))
+	case aiUint64:
+		return !float64FitsFloat32(o.arg)
+	}
+	return true
+}
+
+func float32FitsFloat16(bits uint32) bool {
+	exp := int(bits>>23) & 0xff
+	mant := bits & 0x7fffff
+
+	switch {
+	case exp == 0xff:
+		// infinity, or NaN with a payload that fits in 10 bits
+		return mant&0x1fff == 0
+	case exp == 0:
+		// zero (float32 subnormals are too small for float16)
+		return mant == 0
+	}
+
+	e := exp - 127
+
+	switch {
+	case e >= -14 && e <= 15:
+		// float16 normal
+		return mant&0x1fff == 0
+	case e >= -24 && e < -14:
+		// float16 subnormal: the significand, with its implicit bit, must not
+		// lose any bits when shifted
+		shift := uint(-(e + 1))
+		return (mant|0x800000)&(1<<shift-1) == 0
+	}
+
+	return false
+}
+
+func float64FitsFloat32(bits uint64) bool {
+	f := math.Float64frombits(bits)
+
+	if math.IsNaN(f) {
+		// the payload must fit in 23 bits
+		return bits&0x1fffffff == 0
+	}
+
+	return float64(float32(f)) == f
+}
+
+// appendHead appends the head of a CBOR data item of major type major and
+// argument n, in preferred serialization
+func appendHead(dst []byte, major byte, n uint64) []byte {
+	mt := major << 5
+
+	switch {
+	case n < 24:
+		return append(dst, mt|byte(n))
+	case n <= 0xff:
+		return append(dst, mt|24, byte(n))
+	case n <= 0xffff:
+		return append(dst, mt|25, byte(n>>8), byte(n))
+	case n <= 0xffffffff:
+		return append(dst, mt|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+
+	return append(dst, mt|27,
+		byte(n>>56), byte(n>>48), byte(n>>40), byte(n>>32),
+		byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+// checkDeterministic checks that data is exactly one CBOR data item in core
+// deterministic encoding (RFC 8949, §4.2.1): preferred serialization of
+// arguments and floats, definite lengths and map keys sorted in the bytewise
+// lexicographic order of their encodings, without duplicates
+func checkDeterministic(data []byte) error {
+	n, err := checkDeterministicItem(data, 0)
+	if err != nil {
+		return err
+	}
+
+	if n != len(data) {
+		return fmt.Errorf("%d trailing bytes after the data item", len(data)-n)
+	}
+
+	return nil
+}
+
+// checkDeterministicItem checks the data item at data[off:] and returns the
+// offset of the next one
+func checkDeterministicItem(data []byte, off int) (int, error) {
+	h, err := readHead(data[off:])
+	if err != nil {
+		return 0, fmt.Errorf("at offset %d: %w", off, err)
+	}
+
+	if h.isIndefinite() {
+		return 0, fmt.Errorf("at offset %d: indefinite length", off)
+	}
+
+	if h.isFloat() {
+		if !h.isPreferredFloat() {
+			return 0, fmt.Errorf("at offset %d: float not in its shortest form", off)
+		}
+	} else if !h.isPreferred() {
+		return 0, fmt.Errorf("at offset %d: integer argument not in its shortest form", off)
+	}
+
+	next := off + h.size
+
+	switch h.major {
+	case majorBytes, majorText:
+		if uint64(len(data)-next) < h.arg {
+			return 0, fmt.Errorf("at offset %d: %w", off, errTruncated)
+		}
+		return next + int(h.arg), nil
+	case majorArray:
+		for i := uint64(0); i < h.arg; i++ {
+			if next, err = checkDeterministicItem(data, next); err != nil {
+				return 0, err
+			}
+		}
+	case majorMap:
+		var prev []byte
+		for i := uint64(0); i < h.arg; i++ {
+			start := next
+			if next, err = checkDeterministicItem(data, next); err != nil {
+				return 0, err
+			}
+			key := data[start:next]
+			if prev != nil && bytes.Compare(prev, key) >= 0 {
+				return 0, fmt.Errorf("at offset %d: map keys not sorted or duplicated", start)
+			}
+			prev = key
+			if next, err = checkDeterministicItem(data, next); err != nil {
+				return 0, err
+			}
+		}
+	case majorTag:
+		return checkDeterministicItem(data, next)
+	case majorSimple:
+		if h.ai == aiUint8 && h.arg < 32 {
+			return 0, fmt.Errorf("at offset %d: malformed simple value", off)
+		}
+	}
+
+	return next, nil
+}
@@ -0,0 +1,71 @@
+package href
+
+import (
+	"net"
+	"strings"
+)
+
+// Compact rewrites the CRI reference in place into the smallest equivalent
+// encoding and returns the number of bytes saved in the transfer form (as
+// produced by ToCBOR with the same options).  On top of Normalize:
+//
+//   - a host-name that is an IPv4 address literal (e.g., "192.168.0.1"), which
+//     RFC 3986 reads as an IPv4address rather than a reg-name, becomes a
+//     host-ip.
+//
+// The CRI reference is left untouched if it cannot be encoded.
+func (o *CRI) Compact(opts ...Option) (int, error) {
+	before, err := o.ToCBOR(opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	c := o.Clone()
+	c.compact()
+
+	after, err := c.ToCBOR(opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	*o = *c
+
+	return len(before) - len(after), nil
+}
+
+func (o *CRI) compact() {
+	o.Authority.Host.compact()
+	o.Normalize()
+}
+
+func (o *Host) compact() {
+	var name string
+
+	switch t := o.val.(type) {
+	case string:
+		name = t
+	case []string:
+		// the labels of an IPv4 address literal are its four dot-separated
+		// numbers: a label holding a dot is percent-encoded in the URI
+		if len(t) != net.IPv4len {
+			return
+		}
+		for _, label := range t {
+			if strings.Contains(label, ".") {
+				return
+			}
+		}
+		name = strings.Join(t, ".")
+	default:
+		return
+	}
+
+	// a host-name cannot hold an IPv6 address, which needs brackets
+	if strings.Contains(name, ":") {
+		return
+	}
+
+	if ip4 := net.ParseIP(name).To4(); ip4 != nil {
+		_ = o.SetIP(ip4)
+	}
+}
@@ -1,6 +1,7 @@
 package href
 
 import (
+	"bytes"
 	"fmt"
 	"net/url"
 	"strings"
@@ -17,123 +18,135 @@ type CRI struct {
 	Fragment  Fragment
 }
 
-// Parse ingest a CRI Reference in transfer form into its abstract form
-func Parse(rawCRI []byte) (*CRI, error) {
-	var (
-		pc   *PC
-		eof  bool
-		err  error
-		elem interface{}
-		cri  CRI
-	)
+// Clone returns a deep copy of the CRI reference, which shares no state with
+// the original
+func (o *CRI) Clone() *CRI {
+	return &CRI{
+		// discard and scheme hold immutable values
+		Discard:   o.Discard,
+		Scheme:    o.Scheme,
+		Authority: o.Authority.clone(),
+		Path:      o.Path.clone(),
+		Query:     o.Query.clone(),
+		Fragment:  o.Fragment.clone(),
+	}
+}
 
-	if pc, err = NewPC(rawCRI); err != nil {
+// Parse ingest a CRI Reference in transfer form into its abstract form.  By
+// default, the rules of the latest draft version are applied: use WithVersion
+// to select a different one.  The CRI array may be wrapped in the CRI tag (99),
+// and must not be followed by trailing bytes.  A CRI reference that Validate
+// rejects, e.g. a discard of 0 followed by a path, is rejected too, so that
+// ToCBOR can encode whatever Parse returns.
+func Parse(rawCRI []byte, opts ...Option) (*CRI, error) {
+	var cri CRI
+
+	o, err := newOptions(opts)
+	if err != nil {
 		return nil, err
 	}
 
-	if pc.Empty() {
-		// §5.2 If the array is entirely empty, replace it with [0].
-		_ = cri.Discard.Set(uint64(0))
-		return &cri, nil
+	if o.strict {
+		if err = checkDeterministic(rawCRI); err != nil {
+			return nil, newParseError(ErrNotDeterministic, SectionCRI, -1, fmt.Errorf("%w: %v", ErrNotDeterministic, err))
+		}
 	}
 
-	// If we reached here is because pc!=empty, hence we know we have at least
-	// one element
-	elem, _ = pc.Peek()
+	if err := newCRIDecoder(rawCRI, o.version).decode(&cri); err != nil {
+		return nil, err
+	}
 
-	if isScheme(elem) {
-		if err := cri.Scheme.Set(elem); err != nil {
-			return nil, err
-		}
+	return &cri, nil
+}
 
-		// since "null" is an acceptable value for authority and trailing null's
-		// are suppressed, if we get EOF here, we need to set the authority
-		// explicitly and declare success.
-		if elem, eof = pc.Next(); eof {
-			cri.Authority.SetNull()
-			return &cri, nil
-		}
+// ParseStrict is Parse with WithStrict.  It also reports whether ToCBOR, with
+// the same options, re-encodes the CRI reference byte-for-byte into rawCRI:
+// this is not the case if, e.g., rawCRI has trailing nulls or a [0] in place of
+// the empty array.
+func ParseStrict(rawCRI []byte, opts ...Option) (*CRI, bool, error) {
+	opts = append([]Option{}, opts...)
 
-		if err := cri.Authority.Set(elem); err != nil {
-			return nil, err
-		}
-	} else if isDiscard(elem) {
-		if err := cri.Discard.Set(elem); err != nil {
-			return nil, err
-		}
-	} else {
-		return nil, fmt.Errorf("expecting scheme or discard, got %T", elem)
+	cri, err := Parse(rawCRI, append(opts, WithStrict())...)
+	if err != nil {
+		return nil, false, err
 	}
 
-	if elem, eof = pc.Next(); eof {
-		return &cri, nil
+	if tag, _, tagged, _ := untag(rawCRI); tagged && tag == TagCRI {
+		opts = append(opts, WithTag())
 	}
 
-	if err := cri.Path.Set(elem); err != nil {
-		return nil, err
+	encoded, err := cri.ToCBOR(opts...)
+	if err != nil {
+		return cri, false, nil
 	}
 
-	if elem, eof = pc.Next(); eof {
-		return &cri, nil
-	}
+	return cri, bytes.Equal(encoded, rawCRI), nil
+}
 
-	if err := cri.Query.Set(elem); err != nil {
-		return nil, err
-	}
+// ToCBOR serializes the CRI reference to its transfer form.  By default, the
+// rules of the latest draft version are applied: use WithVersion to select a
+// different one.
+func (o *CRI) ToCBOR(opts ...Option) ([]byte, error) {
+	var cri []interface{}
 
-	if elem, eof = pc.Next(); eof {
-		return &cri, nil
+	opt, err := newOptions(opts)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := cri.Fragment.Set(elem); err != nil {
+	if err = o.Validate(); err != nil {
 		return nil, err
 	}
 
-	if _, eof = pc.Next(); !eof {
-		return nil, fmt.Errorf("spurious trailing elements")
+	if opt.compact {
+		o = o.Clone()
+		o.compact()
 	}
 
-	return &cri, nil
-}
-
-func (o *CRI) ToCBOR() ([]byte, error) {
-	var cri []interface{}
-
-	if o.Scheme.IsSet() {
-		cri = append(cri, o.Scheme.Get())
+	if o.Scheme.IsSet() || o.IsNetworkPath() {
+		cri = append(cri, o.Scheme.toCBOR(opt.schemeID))
 
 		if o.Authority.IsNull {
 			cri = append(cri, nil)
 		} else if o.Authority.IsTrue {
 			cri = append(cri, true)
 		} else {
-			var authority []interface{}
-			authority = append(authority, o.Authority.Host.Get())
-			if o.Authority.Port.IsSet() {
-				authority = append(authority, o.Authority.Port.Get())
+			authority, err := o.Authority.toCBOR(opt.version)
+			if err != nil {
+				return nil, err
 			}
 			cri = append(cri, authority)
 		}
-	} else if o.Discard.IsSet() {
-		cri = append(cri, o.Discard.Get())
 	} else {
-		return nil, fmt.Errorf("neither an absolute CRI nor a relative reference")
+		cri = append(cri, o.Discard.Get())
 	}
 
 	var pathQueryAndFrag []interface{}
 
 	if o.Fragment.IsSet() {
-		pathQueryAndFrag = append(pathQueryAndFrag, o.Fragment.Get())
+		fragment, err := o.Fragment.toCBOR(opt.version)
+		if err != nil {
+			return nil, err
+		}
+		pathQueryAndFrag = append(pathQueryAndFrag, fragment)
 	}
 
 	if o.Query.IsSet() {
-		pathQueryAndFrag = prepend(o.Query.Get(), pathQueryAndFrag)
+		query, err := o.Query.toCBOR(opt.version)
+		if err != nil {
+			return nil, err
+		}
+		pathQueryAndFrag = prepend(query, pathQueryAndFrag)
 	} else if len(pathQueryAndFrag) > 0 {
 		pathQueryAndFrag = prepend(nil, pathQueryAndFrag)
 	}
 
 	if o.Path.IsSet() {
-		pathQueryAndFrag = prepend(o.Path.Get(), pathQueryAndFrag)
+		path, err := o.Path.toCBOR(opt.version)
+		if err != nil {
+			return nil, err
+		}
+		pathQueryAndFrag = prepend(path, pathQueryAndFrag)
 	} else if len(pathQueryAndFrag) > 0 {
 		pathQueryAndFrag = prepend(nil, pathQueryAndFrag)
 	}
@@ -155,9 +168,48 @@ func (o *CRI) ToCBOR() ([]byte, error) {
 		cri = []interface{}{}
 	}
 
+	if opt.tagged {
+		return cbor.Marshal(cbor.Tag{Number: TagCRI, Content: cri})
+	}
+
 	return cbor.Marshal(cri)
 }
 
+// MarshalCBOR implements cbor.Marshaler, so that a CRI reference can be
+// embedded in other CBOR data items (e.g., as a struct field, or in arrays and
+// maps) encoded with github.com/fxamacker/cbor/v2.  The transfer form is that
+// of ToCBOR with the default options.
+//
+// The zero CRI is not a CRI reference, and fails to encode even in a field
+// tagged omitempty: an optional field must be a *CRI, which is omitted when nil.
+func (o *CRI) MarshalCBOR() ([]byte, error) {
+	return o.ToCBOR()
+}
+
+// UnmarshalCBOR implements cbor.Unmarshaler: the data item is decoded with
+// Parse using the default options, unless it is a URI reference wrapped in tag
+// 32, which is decoded with ParseURITag.
+func (o *CRI) UnmarshalCBOR(data []byte) error {
+	var (
+		c   *CRI
+		err error
+	)
+
+	if tag, _, tagged, _ := untag(data); tagged && tag == TagURI {
+		c, err = ParseURITag(data)
+	} else {
+		c, err = Parse(data)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	*o = *c
+
+	return nil
+}
+
 func isDiscardZero(cri []interface{}) bool {
 	if len(cri) != 1 {
 		return false
@@ -180,9 +232,16 @@ func prepend(val interface{}, dst []interface{}) []interface{} {
 // then recomposing the components to a URI reference string as specified in
 // §5.3 of RFC3986.
 func (o *CRI) ToURI() (*url.URL, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
 	scheme := o.toURISchemeRules()
-	host := o.toURIAuthorityRules()
-	path := o.toURIPathRules()
+	user, host, authorityOK := o.toURIAuthorityRules()
+	path, err := o.toURIPathRules()
+	if err != nil {
+		return nil, err
+	}
 	query := o.toURIQueryRules()
 	fragment := o.toURIFragmentRules()
 
@@ -193,42 +252,88 @@ func (o *CRI) ToURI() (*url.URL, error) {
 	// 	[scheme:][//[userinfo@]host][/]path[?query][#fragment]
 	// URLs that do not start with a slash after the scheme are interpreted as:
 	// 	scheme:opaque[?query][#fragment]
+	//
+	// Path and Fragment are in decoded form, their encoded form goes in
+	// RawPath and RawFragment respectively.  Opaque and RawQuery are in
+	// encoded form.
+
+	var u *url.URL
+
+	// If url.URL can't be trusted with encoding the authority, pass the
+	// already encoded authority and path as opaque data
+	if !authorityOK {
+		u = &url.URL{
+			Scheme:      scheme,
+			Opaque:      "//" + o.Authority.String() + path,
+			RawQuery:    query,
+			Fragment:    o.Fragment.Get(),
+			RawFragment: fragment,
+		}
+	} else if scheme != "" && host == "" {
+		u = &url.URL{
+			Scheme:      scheme,
+			Opaque:      path,
+			RawQuery:    query,
+			Fragment:    o.Fragment.Get(),
+			RawFragment: fragment,
+		}
+	} else {
+		decodedPath, err := url.PathUnescape(path)
+		if err != nil {
+			return nil, err
+		}
+
+		u = &url.URL{
+			Scheme:      scheme,
+			User:        user,
+			Host:        host,
+			Path:        decodedPath,
+			RawPath:     path,
+			RawQuery:    query,
+			Fragment:    o.Fragment.Get(),
+			RawFragment: fragment,
+		}
+	}
 
-	//	if scheme != "" && host == "" && len(path) > 0 && path[0] != '/' {
-	if scheme != "" && host == "" {
-		return &url.URL{
-			Scheme:   scheme,
-			Opaque:   path,
-			RawQuery: query,
-			Fragment: fragment,
-		}, nil
-	}
-
-	return &url.URL{
-		Scheme:   scheme,
-		Host:     host,
-		Path:     path,
-		RawQuery: query,
-		Fragment: fragment,
-	}, nil
+	// An empty query, e.g. [""], is not the same as no query
+	u.ForceQuery = o.Query.IsSet() && query == ""
+
+	// url.URL omits an empty fragment: pass all but the scheme as opaque data,
+	// followed by the "#"
+	if o.Fragment.IsSet() && fragment == "" {
+		opaque := u.String()
+		if scheme != "" {
+			opaque = opaque[len(scheme)+1:]
+		}
+		u = &url.URL{Scheme: scheme, Opaque: opaque + "#"}
+	}
+
+	return u, nil
 }
 
 func (o *CRI) toURISchemeRules() string {
 	return o.Scheme.String()
 }
 
-func (o *CRI) toURIAuthorityRules() string {
-	return o.Authority.String()
+func (o *CRI) toURIAuthorityRules() (*url.Userinfo, string, bool) {
+	user, userOK := o.Authority.Userinfo.urlUserinfo()
+	host, hostOK := o.Authority.urlHost()
+
+	return user, host, userOK && hostOK
 }
 
-func (o *CRI) toURIPathRules() string {
+// toURIPathRules returns the percent-encoded path component
+func (o *CRI) toURIPathRules() (string, error) {
 	var (
 		path   string
 		rooted bool
+		err    error
 	)
 
 	if o.Discard.IsSet() {
-		path = o.Discard.ComputePathPrefix()
+		if path, err = o.Discard.ComputePathPrefix(o.Path.IsSet()); err != nil {
+			return "", err
+		}
 	} else if o.Authority.IsTrue { // no auth, no slash
 		rooted = false
 	} else {
@@ -236,46 +341,58 @@ func (o *CRI) toURIPathRules() string {
 	}
 
 	if o.Path.NumSegments() > 0 {
+		segments := o.Path.escapedValues(isPathChar)
+
 		if rooted {
 			path = "/"
+		} else if path == "" && !o.Scheme.IsSet() && (segments[0] == "" || strings.Contains(segments[0], ":")) {
+			// A relative-path reference whose first segment is empty or
+			// contains a colon would be mistaken for an absolute-path
+			// reference or a URI; prefix it with a "." segment (§4.2 of
+			// RFC3986).
+			path = "./"
 		}
-		path += strings.Join(o.Path.GetSegments(), "/")
+		path += strings.Join(segments, "/")
 	}
 
 	// sanity checks
 	//
 	// If the authority component is present (not null or true) and the path
 	// component does not match the "path-abempty" rule the conversion fails.
-	// XXX(tho) should bail out
 	if o.Authority.IsSet() {
 		if !matchPathAbEmpty(path) {
-			fmt.Println("authority is present but path is not path-abempty")
+			return "", ErrPathNotAbEmpty
 		}
 	} else if o.Scheme.IsSet() {
 		// If the authority component is not present, but the scheme component
 		// is, and the path component does not match the "path-absolute",
 		// "path-rootless" (authority == true) or "path-empty" rule the
 		// conversion fails.
-		// XXX(tho) should bail out
-		if !matchPathAbsolute(path) && !matchPathRootless(path) && !matchPathEmpty(path) {
-			fmt.Println("authority is not present but scheme is and path is not absolute, rootless or empty")
+		if o.Authority.IsTrue {
+			if !matchPathRootless(path) && !matchPathEmpty(path) {
+				return "", ErrPathNotAbsRootlessEmpty
+			}
+		} else if !matchPathAbsolute(path) && !matchPathEmpty(path) {
+			return "", ErrPathNotAbsRootlessEmpty
 		}
 	} else {
 		// If neither the authority component nor the scheme component are
 		// present, and the path component does not match the "path-absolute",
 		// "path-noscheme" or "path-empty" rule the conversion fails.
 		if !matchPathAbsolute(path) && !matchPathNoScheme(path) && !matchPathEmpty(path) {
-			fmt.Println("authority and scheme not present and path is not absolute, noscheme or empty")
+			return "", ErrPathNotAbsNoSchemeEmpty
 		}
 	}
 
-	return path
+	return path, nil
 }
 
+// toURIQueryRules returns the percent-encoded query component
 func (o *CRI) toURIQueryRules() string {
 	return o.Query.String()
 }
 
+// toURIFragmentRules returns the percent-encoded fragment component
 func (o *CRI) toURIFragmentRules() string {
 	return o.Fragment.String()
 }
@@ -285,24 +402,34 @@ func (o *CRI) toURIFragmentRules() string {
 // absolute. ResolveReference always returns a new CRI instance, even if the
 // returned CRI is identical to either the base or reference. If ref is an
 // absolute CRI, then ResolveReference ignores base and returns a copy of ref.
-func (o CRI) ResolveReference(ref *CRI) *CRI { // nolint: gocritic
+// Neither base nor ref are modified, and the returned CRI shares no state with
+// them, therefore the same base can be used concurrently.
+//
+// An error is returned if the base is not an absolute CRI (ErrNotAbsoluteBase)
+// or if either the base or ref are not well-formed.
+func (o CRI) ResolveReference(ref *CRI) (*CRI, error) { // nolint: gocritic
 	var resolvedCRI CRI
 
-	if ref.IsAbs() {
-		resolvedCRI = *ref
-		return &resolvedCRI
-	}
-
 	// 1. Establish the base CRI of the CRI reference and express it in the form
 	//    of an abstract absolute CRI reference.
-	//
-	// XXX(tho): we assume 'o' is that "abstract absolute CRI reference" without
-	// checking.  What could we do here to make sure 'o' is fit for purpose?
+	if err := o.Validate(); err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+
+	if !o.IsAbs() {
+		return nil, ErrNotAbsoluteBase
+	}
+
+	if err := ref.Validate(); err != nil {
+		return nil, fmt.Errorf("reference: %w", err)
+	}
+
+	if ref.IsAbs() {
+		return ref.Clone(), nil
+	}
 
 	// 2. Initialize a buffer with the sections from the base CRI.
-	//
-	// XXX(tho) shallow copy, we need to deep copy instead.
-	resolvedCRI = o
+	resolvedCRI = *o.Clone()
 
 	// 3. If the value of discard is true in the CRI reference, replace the path
 	//    in the buffer with the empty array, unset query and fragment, and set
@@ -311,7 +438,8 @@ func (o CRI) ResolveReference(ref *CRI) *CRI { // nolint: gocritic
 	//    is non-zero, unset query and fragment.
 	//
 	// NOTE: discard = DISCARD-ALL is implicitly the case when scheme and/or
-	//       authority are present in the reference.
+	//       authority are present in the reference, the latter being a
+	//       network-path reference if scheme is null.
 	discardAll := ref.Discard.IsTrue() || ref.Scheme.IsSet() || ref.Authority.IsSet()
 
 	if ref.Discard.IsSet() || discardAll {
@@ -322,11 +450,8 @@ func (o CRI) ResolveReference(ref *CRI) *CRI { // nolint: gocritic
 			if resolvedCRI.Authority.IsTrue {
 				resolvedCRI.Authority.SetNull()
 			}
-		} else { // unsigned number
-			n, ok := ref.Discard.Get().(uint64)
-			if !ok {
-				panic("discard is not a number")
-			}
+		} else { // unsigned number (as checked by Validate)
+			n, _ := ref.Discard.Count()
 			resolvedCRI.Path.TrimN(n)
 			if n > 0 {
 				resolvedCRI.Query.Reset()
@@ -336,13 +461,16 @@ func (o CRI) ResolveReference(ref *CRI) *CRI { // nolint: gocritic
 	}
 
 	// (Unconditionally) set discard to true in the buffer.
-	_ = resolvedCRI.Discard.Set(true)
+	//
+	// NOTE: in the abstract form, discard = DISCARD-ALL is implied by the
+	//       scheme of the (absolute) buffer and is therefore left unset.
+	resolvedCRI.Discard = Discard{}
 
 	// 4. If the path section is set in the CRI reference, append all elements
 	//    from the path array to the array in the path section in the buffer;
 	//    unset query and fragment.
 	if ref.Path.IsSet() {
-		resolvedCRI.Path.Append(ref.Path.GetSegments())
+		resolvedCRI.Path.appendFrom(ref.Path.Items, 0)
 		resolvedCRI.Query.Reset()
 		resolvedCRI.Fragment.Reset()
 	}
@@ -354,26 +482,95 @@ func (o CRI) ResolveReference(ref *CRI) *CRI { // nolint: gocritic
 		resolvedCRI.Scheme = ref.Scheme
 	}
 
+	// A network-path reference replaces the authority and keeps the base
+	// scheme.
 	if ref.Authority.IsSet() {
-		// TODO(tho) check that cloning is deep enough
-		resolvedCRI.Authority = ref.Authority
+		resolvedCRI.Authority = ref.Authority.clone()
 	}
 
 	if ref.Query.IsSet() {
-		resolvedCRI.Query = ref.Query
+		resolvedCRI.Query = ref.Query.clone()
 		resolvedCRI.Fragment.Reset()
 	}
 
 	if ref.Fragment.IsSet() {
-		resolvedCRI.Fragment = ref.Fragment
+		resolvedCRI.Fragment = ref.Fragment.clone()
+	}
+
+	return &resolvedCRI, nil
+}
+
+// Validate checks that the CRI reference is well-formed, i.e., that it matches
+// the CDDL of the abstract form and satisfies the constraints of the draft that
+// the CDDL cannot express:
+//
+//   - a discard is not combined with a scheme or an authority;
+//   - a discard of 0 is not followed by a path (§6.1);
+//   - the authority of an absolute CRI is either null, true or has a host, and
+//     port, userinfo and zone-id are only present with a suitable host;
+//   - host-name, userinfo and zone-id, which have no PET form, are valid UTF-8;
+//   - a true authority is not followed by a path that starts with an empty
+//     segment, which would read as a null authority.
+func (o *CRI) Validate() error {
+	if o.Discard.IsSet() {
+		switch t := o.Discard.Get().(type) {
+		case bool:
+			if !t {
+				return fmt.Errorf("discard cannot be false")
+			}
+		case uint64:
+			if t > 127 {
+				return fmt.Errorf("discard must be in range 0..127, got %d", t)
+			}
+		default:
+			return fmt.Errorf("unknown discard type: %T", t)
+		}
+
+		if o.Scheme.IsSet() || o.Authority.IsSet() || o.Authority.IsNull || o.Authority.IsTrue {
+			return fmt.Errorf("discard cannot be combined with scheme or authority")
+		}
+	} else if !o.Scheme.IsSet() && !o.IsNetworkPath() {
+		return fmt.Errorf("neither an absolute CRI nor a relative reference")
 	}
 
-	return &resolvedCRI
+	if err := o.Scheme.validate(); err != nil {
+		return err
+	}
+
+	if err := o.Authority.validate(); err != nil {
+		return err
+	}
+
+	if o.Scheme.IsSet() && !o.Authority.IsSet() && !o.Authority.IsNull && !o.Authority.IsTrue {
+		return fmt.Errorf("an absolute CRI needs an authority that is null, true or has a host")
+	}
+
+	return o.validatePath()
+}
+
+// validatePath checks the path against the discard and the authority.  Parse
+// applies it too, so that whatever it accepts can be encoded by ToCBOR.
+func (o *CRI) validatePath() error {
+	if n, ok := o.Discard.Count(); ok && n == 0 && o.Path.IsSet() {
+		return ErrDiscardZeroWithPath
+	}
+
+	if o.Authority.IsTrue && o.Path.IsSet() && o.Path.values[0] == "" {
+		return fmt.Errorf("%w: true authority followed by an empty segment", ErrPathNotAbsRootlessEmpty)
+	}
+
+	return nil
+}
+
+// IsNetworkPath tells whether the CRI reference is a network-path reference,
+// i.e., it has an authority but no scheme (URI "//host/path")
+func (o *CRI) IsNetworkPath() bool {
+	return !o.Scheme.IsSet() && o.Authority.IsSet()
 }
 
 func (o *CRI) IsAbs() bool {
 	// A CRI reference is considered _absolute_ if
-	// a) it is well-formed (TODO(tho)), and
+	// a) it is well-formed, and
 	// b) the sequence of sections starts with a non-null "scheme".
-	return o.Scheme.IsSet()
+	return o.Scheme.IsSet() && o.Validate() == nil
 }
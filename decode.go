@@ -0,0 +1,730 @@
+package href
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// malformedError reports CBOR that is not well-formed, as opposed to a CRI
+// reference that violates a constraint
+type malformedError struct {
+	err error
+}
+
+func (e malformedError) Error() string {
+	return e.err.Error()
+}
+
+func (e malformedError) Unwrap() error {
+	return e.err
+}
+
+// criDecoder decodes a CRI reference in transfer form in a single pass over
+// its encoding, filling the fields of a CRI as the data items are read, with
+// no intermediate representation
+type criDecoder struct {
+	data []byte
+	off  int
+	ver  Version
+
+	// dottedName is set when decoding the value given to a setter, where a
+	// host-name made of a single text is in its dotted form, as in Host.Set
+	dottedName bool
+
+	// str is data as a string, from which the text strings are sliced, so
+	// that they cost a single allocation.  It is set on the first text.
+	str string
+}
+
+func newCRIDecoder(data []byte, ver Version) *criDecoder {
+	return &criDecoder{data: data, ver: ver}
+}
+
+func (d *criDecoder) malformed(off int, format string, a ...interface{}) error {
+	return malformedError{fmt.Errorf("at offset %d: %s", off, fmt.Sprintf(format, a...))}
+}
+
+// fail wraps err in a *ParseError.  Malformed CBOR is reported with the
+// ErrInvalidCBOR kind, whatever the section.
+func (d *criDecoder) fail(kind error, section string, index int, err error) error {
+	var me malformedError
+	if errors.As(err, &me) {
+		kind = ErrInvalidCBOR
+	}
+	return newParseError(kind, section, index, err)
+}
+
+// head reads the head of the next data item
+func (d *criDecoder) head() (cborHead, error) {
+	h, err := readHead(d.data[d.off:])
+	if err != nil {
+		return cborHead{}, malformedError{fmt.Errorf("at offset %d: %w", d.off, err)}
+	}
+
+	if h.major == majorSimple && h.isIndefinite() {
+		return cborHead{}, d.malformed(d.off, "malformed CBOR: unexpected break")
+	}
+
+	d.off += h.size
+
+	return h, nil
+}
+
+// take consumes the next n bytes
+func (d *criDecoder) take(n uint64) ([]byte, error) {
+	if uint64(len(d.data)-d.off) < n {
+		return nil, malformedError{fmt.Errorf("at offset %d: %w", d.off, errTruncated)}
+	}
+
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+
+	return b, nil
+}
+
+// content reads the content of the byte or text string whose head is h.  The
+// chunks of an indefinite-length string are concatenated.  Text must be valid
+// UTF-8.
+func (d *criDecoder) content(h cborHead) ([]byte, error) {
+	if !h.isIndefinite() {
+		start := d.off
+		b, err := d.take(h.arg)
+		if err != nil {
+			return nil, err
+		}
+		if h.major == majorText && !utf8.Valid(b) {
+			return nil, d.malformed(start, "invalid UTF-8 text string")
+		}
+		return b, nil
+	}
+
+	var s []byte
+
+	for {
+		if d.off == len(d.data) {
+			return nil, malformedError{fmt.Errorf("at offset %d: %w", d.off, errTruncated)}
+		}
+
+		if d.data[d.off] == breakCode {
+			d.off++
+			return s, nil
+		}
+
+		start := d.off
+
+		c, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+
+		if c.major != h.major || c.isIndefinite() {
+			return nil, d.malformed(start, "malformed CBOR: bad chunk in indefinite-length string")
+		}
+
+		chunk, err := d.content(c)
+		if err != nil {
+			return nil, err
+		}
+
+		s = append(s, chunk...)
+	}
+}
+
+// text reads the content of the text string whose head is h
+func (d *criDecoder) text(h cborHead) (string, error) {
+	if h.isIndefinite() {
+		b, err := d.content(h)
+		return string(b), err
+	}
+
+	start := d.off
+
+	if _, err := d.content(h); err != nil {
+		return "", err
+	}
+
+	if d.str == "" {
+		d.str = string(d.data)
+	}
+
+	return d.str[start:d.off], nil
+}
+
+// skip skips the next data item
+func (d *criDecoder) skip(depth int) error {
+	if depth > maxNesting {
+		return d.malformed(d.off, "data item nested too deeply")
+	}
+
+	h, err := d.head()
+	if err != nil {
+		return err
+	}
+
+	switch h.major {
+	case majorBytes, majorText:
+		_, err = d.content(h)
+		return err
+	case majorArray, majorMap:
+		a := d.array(h)
+		for {
+			more, err := a.more()
+			if err != nil {
+				return err
+			}
+			if !more {
+				return a.close()
+			}
+			a.i++
+			if err := d.skip(depth + 1); err != nil {
+				return err
+			}
+		}
+	case majorTag:
+		return d.skip(depth + 1)
+	}
+
+	return nil
+}
+
+// arrayReader iterates over the elements of an array, or of a map, whose
+// elements are then its keys and values
+type arrayReader struct {
+	d *criDecoder
+	h cborHead
+	// i is the number of elements read
+	i uint64
+}
+
+func (d *criDecoder) array(h cborHead) *arrayReader {
+	return &arrayReader{d: d, h: h}
+}
+
+// more tells whether there are elements left to read
+func (o *arrayReader) more() (bool, error) {
+	if !o.h.isIndefinite() {
+		n := o.h.arg
+		if o.h.major == majorMap {
+			if n > math.MaxUint64/2 {
+				return false, o.d.malformed(o.d.off, "data item too long")
+			}
+			n *= 2
+		}
+		return o.i < n, nil
+	}
+
+	if o.d.off == len(o.d.data) {
+		return false, malformedError{fmt.Errorf("at offset %d: %w", o.d.off, errTruncated)}
+	}
+
+	return o.d.data[o.d.off] != breakCode, nil
+}
+
+// next reads the head of the next element.  At the end of the array, ok is
+// false.
+func (o *arrayReader) next() (h cborHead, ok bool, err error) {
+	if ok, err = o.more(); !ok || err != nil {
+		return cborHead{}, false, err
+	}
+
+	o.i++
+
+	h, err = o.d.head()
+
+	return h, err == nil, err
+}
+
+// peek returns the head of the next element, without reading it
+func (o *arrayReader) peek() (h cborHead, ok bool, err error) {
+	off := o.d.off
+
+	h, ok, err = o.next()
+	if ok {
+		o.i--
+		o.d.off = off
+	}
+
+	return h, ok, err
+}
+
+// close reads the end of the array, once there are no elements left
+func (o *arrayReader) close() error {
+	if o.h.isIndefinite() {
+		// the "break" stop code
+		o.d.off++
+	}
+	return nil
+}
+
+// count returns the number of elements of the array, skipping the elements
+// left to read
+func (o *arrayReader) count() (uint64, error) {
+	for {
+		more, err := o.more()
+		if err != nil {
+			return 0, err
+		}
+		if !more {
+			return o.i, o.close()
+		}
+		o.i++
+		if err := o.d.skip(1); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// decode decodes the CRI reference into cri
+func (d *criDecoder) decode(cri *CRI) error {
+	h, err := d.head()
+	if err != nil {
+		return d.fail(ErrInvalidCBOR, SectionCRI, -1, err)
+	}
+
+	if h.major == majorTag {
+		if h.arg != TagCRI {
+			return newParseError(ErrInvalidCBOR, SectionCRI, -1, fmt.Errorf("unexpected tag %d", h.arg))
+		}
+		if h, err = d.head(); err != nil {
+			return d.fail(ErrInvalidCBOR, SectionCRI, -1, err)
+		}
+	}
+
+	if h.major != majorArray {
+		return newParseError(ErrInvalidCBOR, SectionCRI, -1, fmt.Errorf("expecting array, got %s", h.typeName()))
+	}
+
+	if err := d.elements(cri, d.array(h)); err != nil {
+		return err
+	}
+
+	if n := len(d.data) - d.off; n != 0 {
+		return newParseError(ErrInvalidCBOR, SectionCRI, -1, fmt.Errorf("%d trailing bytes after the data item", n))
+	}
+
+	return nil
+}
+
+// elements decodes the elements of the CRI array
+func (d *criDecoder) elements(cri *CRI, a *arrayReader) error {
+	h, ok, err := a.next()
+	if err != nil {
+		return d.fail(ErrInvalidCBOR, SectionCRI, 0, err)
+	}
+
+	if !ok {
+		// §5.2 If the array is entirely empty, replace it with [0].
+		_ = cri.Discard.SetCount(0)
+		return a.close()
+	}
+
+	switch {
+	case h.major == majorText || h.major == majorNint || h.isSimple(simpleNull):
+		if err := d.scheme(&cri.Scheme, h); err != nil {
+			return d.fail(ErrBadScheme, SectionScheme, 0, err)
+		}
+
+		// since "null" is an acceptable value for authority and trailing null's
+		// are suppressed, if we get to the end here, we need to set the
+		// authority explicitly and declare success.
+		if h, ok, err = a.next(); err != nil {
+			return d.fail(ErrInvalidCBOR, SectionCRI, 1, err)
+		} else if !ok {
+			if !cri.Scheme.IsSet() {
+				return newParseError(ErrNetworkPathNoAuthority, SectionAuthority, 1, ErrNetworkPathNoAuthority)
+			}
+			cri.Authority.SetNull()
+			return a.close()
+		}
+
+		if err := d.authority(&cri.Authority, h); err != nil {
+			return d.fail(ErrBadAuthority, SectionAuthority, 1, err)
+		}
+
+		// A null scheme followed by an authority is a network-path reference
+		// ("//host/path"); without an authority it has no URI counterpart.
+		if !cri.Scheme.IsSet() && !cri.Authority.IsSet() {
+			return newParseError(ErrNetworkPathNoAuthority, SectionAuthority, 1, ErrNetworkPathNoAuthority)
+		}
+	case h.major == majorUint || h.isSimple(simpleTrue) || h.isSimple(simpleFalse):
+		if err := d.discard(&cri.Discard, h); err != nil {
+			return d.fail(ErrBadDiscard, SectionDiscard, 0, err)
+		}
+	default:
+		err := fmt.Errorf("expecting scheme or discard, got %s", h.typeName())
+		return newParseError(ErrBadScheme, SectionScheme, 0, err)
+	}
+
+	if h, ok, err = d.nextElement(a); !ok {
+		return err
+	}
+
+	if err := d.items(&cri.Path.Items, h); err != nil {
+		return d.fail(ErrBadPath, SectionPath, int(a.i)-1, err)
+	}
+
+	if err := cri.validatePath(); err != nil {
+		return newParseError(ErrBadPath, SectionPath, int(a.i)-1, err)
+	}
+
+	if h, ok, err = d.nextElement(a); !ok {
+		return err
+	}
+
+	if err := d.items(&cri.Query.Items, h); err != nil {
+		return d.fail(ErrBadQuery, SectionQuery, int(a.i)-1, err)
+	}
+
+	if h, ok, err = d.nextElement(a); !ok {
+		return err
+	}
+
+	if err := d.fragment(&cri.Fragment, h); err != nil {
+		return d.fail(ErrBadFragment, SectionFragment, int(a.i)-1, err)
+	}
+
+	if more, err := a.more(); err != nil {
+		return d.fail(ErrInvalidCBOR, SectionCRI, int(a.i), err)
+	} else if more {
+		return newParseError(ErrTrailingElements, SectionCRI, int(a.i), ErrTrailingElements)
+	}
+
+	return a.close()
+}
+
+// nextElement reads the head of the next element of the CRI array.  At the
+// end of the array, or on error, ok is false.
+func (d *criDecoder) nextElement(a *arrayReader) (h cborHead, ok bool, err error) {
+	index := int(a.i)
+
+	if h, ok, err = a.next(); err != nil {
+		return h, false, d.fail(ErrInvalidCBOR, SectionCRI, index, err)
+	} else if !ok {
+		return h, false, a.close()
+	}
+
+	return h, true, nil
+}
+
+func (d *criDecoder) scheme(s *Scheme, h cborHead) error {
+	switch h.major {
+	case majorText:
+		// scheme-name
+		name, err := d.text(h)
+		if err != nil {
+			return err
+		}
+		return s.SetName(name)
+	case majorNint:
+		// scheme-id
+		if h.arg > math.MaxInt64 {
+			return fmt.Errorf("scheme-id out of range: -1-%d", h.arg)
+		}
+		return s.SetID(-1 - int64(h.arg))
+	}
+
+	// no scheme
+	s.val = nil
+
+	return nil
+}
+
+func (d *criDecoder) discard(o *Discard, h cborHead) error {
+	switch {
+	case h.major == majorUint:
+		return o.SetCount(h.arg)
+	case h.isSimple(simpleTrue):
+		o.SetAll()
+		return nil
+	}
+
+	return errors.New("discard cannot be false")
+}
+
+func (d *criDecoder) authority(o *Authority, h cborHead) error {
+	switch {
+	case h.major == majorArray:
+		return d.hostPort(o, h)
+	case h.isSimple(simpleNull):
+		o.SetNull()
+	case h.isSimple(simpleTrue):
+		o.SetTrue()
+	default:
+		return fmt.Errorf("unexpected authority type: %s", h.typeName())
+	}
+
+	return nil
+}
+
+// hostPort decodes an authority array, like Authority.SetHostPort
+func (d *criDecoder) hostPort(o *Authority, h cborHead) error {
+	var (
+		userinfo Userinfo
+		host     Host
+		port     Port
+	)
+
+	a := d.array(h)
+
+	wrongNumber := func() error {
+		n, err := a.count()
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("wrong number of elements in authority: %d", n)
+	}
+
+	// userinfo
+	e, ok, err := a.peek()
+	if err != nil {
+		return err
+	}
+
+	if ok && e.isSimple(simpleFalse) {
+		if d.ver == Href09 {
+			return fmt.Errorf("userinfo not supported in %s", d.ver)
+		}
+		_, _, _ = a.next()
+		if e, ok, err = a.next(); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("missing userinfo after false marker")
+		}
+		if e.major != majorText {
+			return fmt.Errorf("unexpected userinfo type: %s", e.typeName())
+		}
+		s, err := d.text(e)
+		if err != nil {
+			return err
+		}
+		userinfo.val = &s
+	}
+
+	// host
+	if e, ok, err = a.next(); err != nil {
+		return err
+	} else if !ok {
+		return wrongNumber()
+	}
+
+	switch e.major {
+	case majorText:
+		label, err := d.text(e)
+		if err != nil {
+			return err
+		}
+		if d.ver == Href09 {
+			// host-name is a single text
+			host.SetName(label)
+			break
+		}
+		labels := []string{label}
+		for {
+			if e, ok, err = a.peek(); err != nil {
+				return err
+			} else if !ok || e.major != majorText {
+				break
+			}
+			_, _, _ = a.next()
+			if label, err = d.text(e); err != nil {
+				return err
+			}
+			labels = append(labels, label)
+		}
+		if d.dottedName && len(labels) == 1 {
+			host.SetName(label)
+			break
+		}
+		host.val = labels
+	case majorBytes:
+		ip, err := d.content(e)
+		if err != nil {
+			return err
+		}
+		if err := host.SetIP(ip); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown host type: %s", e.typeName())
+	}
+
+	// zone-id
+	if e, ok, err = a.peek(); err != nil {
+		return err
+	} else if ok && e.major == majorText {
+		if d.ver == Href09 {
+			return fmt.Errorf("zone-id not supported in %s", d.ver)
+		}
+		_, _, _ = a.next()
+		zone, err := d.text(e)
+		if err != nil {
+			return err
+		}
+		if err := host.SetZone(zone); err != nil {
+			return err
+		}
+	}
+
+	// port
+	if e, ok, err = a.next(); err != nil {
+		return err
+	} else if ok {
+		if e.major != majorUint {
+			return fmt.Errorf("unexpected port type: %s", e.typeName())
+		}
+		if e.arg > 65535 {
+			return fmt.Errorf("%w: got %d", ErrPortRange, e.arg)
+		}
+		p := e.arg
+		port.val = &p
+	}
+
+	if more, err := a.more(); err != nil {
+		return err
+	} else if more {
+		return wrongNumber()
+	}
+
+	o.Userinfo = userinfo
+	o.Host = host
+	o.Port = port
+	o.IsTrue = false
+	o.IsNull = false
+
+	return a.close()
+}
+
+// items decodes a path or a query:
+//
+//	path  = [*text-or-pet]
+//	query = [*text-or-pet]
+//
+// null, as found in place of a suppressed path followed by a query, is no
+// items.
+func (d *criDecoder) items(o *Items, h cborHead) error {
+	if h.isSimple(simpleNull) {
+		return nil
+	}
+
+	if h.major != majorArray {
+		return fmt.Errorf("unknown type: %s", h.typeName())
+	}
+
+	a := d.array(h)
+
+	var values Items
+
+	if !h.isIndefinite() && h.arg > 0 {
+		// each element takes at least one byte
+		n := h.arg
+		if rest := uint64(len(d.data) - d.off); n > rest {
+			n = rest
+		}
+		values.values = make([]string, 0, n)
+	}
+
+	for {
+		e, ok, err := a.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if e.major != majorText && e.major != majorArray {
+			return fmt.Errorf("unknow type for item: %s", e.typeName())
+		}
+		s, p, err := d.textOrPET(e)
+		if err != nil {
+			return err
+		}
+		values.appendValue(s, p)
+	}
+
+	o.appendFrom(values, 0)
+
+	return a.close()
+}
+
+func (d *criDecoder) fragment(o *Fragment, h cborHead) error {
+	if h.major != majorText && h.major != majorArray {
+		return fmt.Errorf("unknown type for fragment: %s", h.typeName())
+	}
+
+	s, p, err := d.textOrPET(h)
+	if err != nil {
+		return err
+	}
+
+	o.val = &s
+	o.pet = p
+
+	return nil
+}
+
+// textOrPET decodes a text or a percent-encoded text (PET).  The value of a
+// PET is returned with its elements.
+func (d *criDecoder) textOrPET(h cborHead) (string, pet, error) {
+	if h.major == majorText {
+		s, err := d.text(h)
+		return s, nil, err
+	}
+
+	if d.ver == Href09 {
+		return "", nil, fmt.Errorf("percent-encoded text not supported in %s", d.ver)
+	}
+
+	a := d.array(h)
+
+	var (
+		s []byte
+		p pet
+	)
+
+	for {
+		e, ok, err := a.next()
+		if err != nil {
+			return "", nil, err
+		}
+		if !ok {
+			break
+		}
+		if e.major != majorText && e.major != majorBytes {
+			return "", nil, fmt.Errorf("unknown type for percent-encoded text element: %s", e.typeName())
+		}
+		b, err := d.content(e)
+		if err != nil {
+			return "", nil, err
+		}
+		s = append(s, b...)
+		p = append(p, petPart{s: string(b), bytes: e.major == majorBytes})
+	}
+
+	if a.i == 0 {
+		return "", nil, fmt.Errorf("percent-encoded text cannot be empty")
+	}
+
+	return string(s), p, a.close()
+}
+
+// decodeValue encodes v, a data item in its decoded form (as returned by
+// cbor.Unmarshal into an interface{}), and reads its head, so that it can be
+// decoded according to the rules of the latest version, except that a
+// host-name made of a single text is in its dotted form
+func decodeValue(v interface{}) (*criDecoder, cborHead, error) {
+	data, err := cbor.Marshal(v)
+	if err != nil {
+		return nil, cborHead{}, err
+	}
+
+	d := newCRIDecoder(data, LatestVersion)
+	d.dottedName = true
+
+	h, err := d.head()
+
+	return d, h, err
+}
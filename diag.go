@@ -0,0 +1,412 @@
+package href
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Diag returns the transfer form of the CRI reference (as produced by ToCBOR
+// with the default options) in CBOR Extended Diagnostic Notation (EDN), e.g.:
+//
+//	[-1, ["acme", "example"], ["a", "b"]]
+//
+// If the CRI reference cannot be encoded, the error is returned in an EDN
+// comment.
+func (o *CRI) Diag() string {
+	data, err := o.ToCBOR()
+	if err != nil {
+		return "# " + err.Error()
+	}
+
+	var v interface{}
+
+	if err := cbor.Unmarshal(data, &v); err != nil {
+		return "# " + err.Error()
+	}
+
+	var b strings.Builder
+
+	writeDiag(&b, v)
+
+	return b.String()
+}
+
+func writeDiag(b *strings.Builder, v interface{}) {
+	switch t := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(t))
+	case uint64:
+		b.WriteString(strconv.FormatUint(t, 10))
+	case int64:
+		b.WriteString(strconv.FormatInt(t, 10))
+	case string:
+		writeDiagText(b, t)
+	case []byte:
+		b.WriteString("h'" + hex.EncodeToString(t) + "'")
+	case []interface{}:
+		b.WriteByte('[')
+		for i, e := range t {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeDiag(b, e)
+		}
+		b.WriteByte(']')
+	case cbor.Tag:
+		b.WriteString(strconv.FormatUint(t.Number, 10) + "(")
+		writeDiag(b, t.Content)
+		b.WriteByte(')')
+	default:
+		// not found in a CRI
+		fmt.Fprintf(b, "/ unsupported %T /", t)
+	}
+}
+
+func writeDiagText(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(b, "\\u%04x", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+}
+
+// ParseDiag parses a CRI reference in CBOR Extended Diagnostic Notation (EDN),
+// as returned by Diag, into its abstract form.  The supported subset of EDN is
+// that needed by CRI references: arrays, integers, text strings, byte strings
+// (h'...' and '...'), true, false, null, tags and comments.  The application
+// extension literal cri'...' is also accepted in place of a CRI array: it
+// holds a URI reference, which is converted using FromURI and encoded with the
+// same options.
+func ParseDiag(s string, opts ...Option) (*CRI, error) {
+	data, err := diagToCBOR(s, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data, opts...)
+}
+
+// diagToCBOR encodes an EDN data item.  The options apply to the encoding of
+// cri'...' literals.
+func diagToCBOR(s string, opts []Option) ([]byte, error) {
+	p := &diagParser{s: s, opts: opts}
+
+	data, err := p.item(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	p.skip()
+
+	if p.pos != len(p.s) {
+		return nil, p.errorf("unexpected trailing characters")
+	}
+
+	return data, nil
+}
+
+type diagParser struct {
+	s    string
+	pos  int
+	opts []Option
+}
+
+func (o *diagParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("EDN at offset %d: %s", o.pos, fmt.Sprintf(format, a...))
+}
+
+// skip skips white space and comments
+func (o *diagParser) skip() {
+	for o.pos < len(o.s) {
+		switch c := o.s[o.pos]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			o.pos++
+		case c == '/':
+			end := strings.IndexByte(o.s[o.pos+1:], '/')
+			if end < 0 {
+				return
+			}
+			o.pos += end + 2
+		case c == '#':
+			end := strings.IndexByte(o.s[o.pos:], '\n')
+			if end < 0 {
+				o.pos = len(o.s)
+				return
+			}
+			o.pos += end + 1
+		default:
+			return
+		}
+	}
+}
+
+func (o *diagParser) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(o.s[o.pos:], prefix)
+}
+
+// item appends the encoding of the next data item to dst
+func (o *diagParser) item(dst []byte) ([]byte, error) {
+	o.skip()
+
+	if o.pos == len(o.s) {
+		return nil, o.errorf("unexpected end of input")
+	}
+
+	switch c := o.s[o.pos]; {
+	case c == '[':
+		return o.array(dst)
+	case c == '"':
+		s, err := o.text('"')
+		if err != nil {
+			return nil, err
+		}
+		return append(appendHead(dst, 3, uint64(len(s))), s...), nil
+	case c == '\'':
+		s, err := o.text('\'')
+		if err != nil {
+			return nil, err
+		}
+		return append(appendHead(dst, 2, uint64(len(s))), s...), nil
+	case o.hasPrefix("h'"):
+		o.pos++
+		s, err := o.text('\'')
+		if err != nil {
+			return nil, err
+		}
+		b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+		if err != nil {
+			return nil, o.errorf("bad hex byte string: %v", err)
+		}
+		return append(appendHead(dst, 2, uint64(len(b))), b...), nil
+	case o.hasPrefix("cri'"):
+		o.pos += 3
+		s, err := o.text('\'')
+		if err != nil {
+			return nil, err
+		}
+		c, err := FromURI(s)
+		if err != nil {
+			return nil, o.errorf("cri literal: %v", err)
+		}
+		b, err := c.ToCBOR(o.opts...)
+		if err != nil {
+			return nil, o.errorf("cri literal: %v", err)
+		}
+		return append(dst, b...), nil
+	case o.hasPrefix("true"):
+		o.pos += 4
+		return append(dst, 0xf5), nil
+	case o.hasPrefix("false"):
+		o.pos += 5
+		return append(dst, 0xf4), nil
+	case o.hasPrefix("null"):
+		o.pos += 4
+		return append(dst, 0xf6), nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		return o.number(dst)
+	}
+
+	return nil, o.errorf("unexpected character %q", o.s[o.pos])
+}
+
+func (o *diagParser) array(dst []byte) ([]byte, error) {
+	var (
+		elems []byte
+		n     uint64
+		err   error
+	)
+
+	o.pos++ // '['
+
+	for {
+		o.skip()
+
+		if o.pos == len(o.s) {
+			return nil, o.errorf("unexpected end of input")
+		}
+
+		if o.hasPrefix("]") {
+			o.pos++
+			break
+		}
+
+		if n > 0 {
+			if !o.hasPrefix(",") {
+				return nil, o.errorf("expecting ',' or ']'")
+			}
+			o.pos++
+		}
+
+		if elems, err = o.item(elems); err != nil {
+			return nil, err
+		}
+		n++
+	}
+
+	return append(appendHead(dst, 4, n), elems...), nil
+}
+
+// number parses an integer, or a tag if followed by '('
+func (o *diagParser) number(dst []byte) ([]byte, error) {
+	start := o.pos
+
+	if o.s[o.pos] == '-' {
+		o.pos++
+	}
+
+	for o.pos < len(o.s) && o.s[o.pos] >= '0' && o.s[o.pos] <= '9' {
+		o.pos++
+	}
+
+	lit := o.s[start:o.pos]
+
+	if o.hasPrefix("(") {
+		tag, err := strconv.ParseUint(lit, 10, 64)
+		if err != nil {
+			return nil, o.errorf("bad tag number %q", lit)
+		}
+
+		o.pos++ // '('
+
+		if dst, err = o.item(appendHead(dst, 6, tag)); err != nil {
+			return nil, err
+		}
+
+		o.skip()
+
+		if !o.hasPrefix(")") {
+			return nil, o.errorf("expecting ')'")
+		}
+		o.pos++
+
+		return dst, nil
+	}
+
+	if strings.HasPrefix(lit, "-") {
+		n, err := strconv.ParseInt(lit, 10, 64)
+		if err != nil {
+			return nil, o.errorf("bad integer %q", lit)
+		}
+		if n == 0 {
+			// -0 is 0
+			return appendHead(dst, 0, 0), nil
+		}
+		return appendHead(dst, 1, uint64(-(n + 1))), nil
+	}
+
+	n, err := strconv.ParseUint(lit, 10, 64)
+	if err != nil {
+		return nil, o.errorf("bad integer %q", lit)
+	}
+
+	return appendHead(dst, 0, n), nil
+}
+
+// text parses a string delimited by quote, with JSON-like escapes
+func (o *diagParser) text(quote byte) (string, error) {
+	var b strings.Builder
+
+	o.pos++ // opening quote
+
+	for o.pos < len(o.s) {
+		c := o.s[o.pos]
+
+		switch c {
+		case quote:
+			o.pos++
+			return b.String(), nil
+		case '\\':
+			if o.pos+1 == len(o.s) {
+				return "", o.errorf("unterminated escape")
+			}
+			o.pos++
+			switch e := o.s[o.pos]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'u':
+				r, err := o.unicodeEscape()
+				if err != nil {
+					return "", err
+				}
+				b.WriteRune(r)
+			default:
+				// \", \', \\, \/
+				b.WriteByte(e)
+			}
+			o.pos++
+		default:
+			_, size := utf8.DecodeRuneInString(o.s[o.pos:])
+			b.WriteString(o.s[o.pos : o.pos+size])
+			o.pos += size
+		}
+	}
+
+	return "", o.errorf("unterminated string")
+}
+
+// unicodeEscape parses the hex digits of a \u escape (o.pos is at 'u'), and
+// those of the low surrogate that follows a high surrogate.  On return, o.pos
+// is at the last hex digit.
+func (o *diagParser) unicodeEscape() (rune, error) {
+	hex4 := func() (rune, error) {
+		if o.pos+5 > len(o.s) {
+			return 0, o.errorf("bad \\u escape")
+		}
+		r, err := strconv.ParseUint(o.s[o.pos+1:o.pos+5], 16, 16)
+		if err != nil {
+			return 0, o.errorf("bad \\u escape")
+		}
+		o.pos += 4
+		return rune(r), nil
+	}
+
+	r, err := hex4()
+	if err != nil {
+		return 0, err
+	}
+
+	if !utf16.IsSurrogate(r) {
+		return r, nil
+	}
+
+	if !strings.HasPrefix(o.s[o.pos+1:], "\\u") {
+		return 0, o.errorf("lone surrogate in \\u escape")
+	}
+
+	o.pos += 2
+
+	r2, err := hex4()
+	if err != nil {
+		return 0, err
+	}
+
+	if r = utf16.DecodeRune(r, r2); r == utf8.RuneError {
+		return 0, o.errorf("bad surrogate pair in \\u escape")
+	}
+
+	return r, nil
+}
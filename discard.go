@@ -14,16 +14,6 @@ type Discard struct {
 	val interface{}
 }
 
-func isDiscard(e interface{}) bool {
-	switch t := e.(type) {
-	case bool: // true
-		return t
-	case uint64: // 0..127
-		return t <= 127
-	}
-	return false
-}
-
 func (o Discard) IsSet() bool {
 	return o.val != nil
 }
@@ -32,60 +22,69 @@ func (o Discard) Get() interface{} {
 	return o.val
 }
 
+// IsTrue tells whether the discard is true, i.e., the whole path of the base
+// is discarded.  It is equivalent to All.
 func (o Discard) IsTrue() bool {
-	if !o.IsSet() {
-		return false
-	}
+	return o.All()
+}
 
-	v := o.Get()
+// All tells whether the discard is true, i.e., the whole path of the base is
+// discarded
+func (o Discard) All() bool {
+	// Set never stores a false boolean
+	_, ok := o.val.(bool)
+	return ok
+}
 
-	if t, ok := v.(bool); ok {
-		if !t {
-			panic("boolean discard cannot be false")
-		}
-		return true
-	}
+// Count returns the number of trailing path segments of the base to discard,
+// if the discard is an unsigned number
+func (o Discard) Count() (uint64, bool) {
+	n, ok := o.val.(uint64)
+	return n, ok
+}
+
+// SetAll sets the discard to true
+func (o *Discard) SetAll() {
+	o.val = true
+}
 
-	return false
+// SetCount sets the discard to the unsigned number n (0..127)
+func (o *Discard) SetCount(n uint64) error {
+	if n > 127 {
+		return fmt.Errorf("discard must be in range 0..127, got %d", n)
+	}
+	o.val = n
+	return nil
 }
 
-// TODO(tho) handle failure condition "discard==0 && path item present"
-func (o Discard) ComputePathPrefix() string {
-	var pathPrefix string
+// ComputePathPrefix returns the prefix of the URI path component that
+// corresponds to the discard item, per §6.1 of href-09.  hasPath tells whether
+// the path item is present in the CRI reference.
+func (o Discard) ComputePathPrefix(hasPath bool) (string, error) {
+	// If the CRI reference contains a discard item of value true, the path
+	// component is prefixed by a slash ("/") character.
+	if o.All() {
+		return "/", nil
+	}
 
-	v := o.Get()
+	n, ok := o.Count()
+	if !ok {
+		return "", errors.New("discard is not set")
+	}
 
-	switch t := v.(type) {
-	case bool:
-		// If the CRI reference contains a discard item of value true, the path
-		// component is prefixed by a slash ("/") character.
-		if !t {
-			panic("boolean discard cannot be false")
-		}
-		pathPrefix = "/"
-	case uint64:
-		// If it contains a discard item of value 0 and the path item is
-		// present, the conversion fails.
-		//   XXX(tho) we are missing the path context to decide
-		//   XXX(tho) whether to fail or not.
-		// If it contains a positive discard item, the path component is
-		// prefixed by as many "../" components as the discard value minus one
-		// indicates.
-		if t > 0 {
-			var (
-				i uint64
-				a []string
-			)
-			for i = 0; i < t-1; i++ {
-				a = append(a, "../")
-			}
-			pathPrefix = strings.Join(a, "")
-		}
-	default:
-		panic(fmt.Sprintf("unknown type %T for discard", t))
+	// If it contains a discard item of value 0 and the path item is present,
+	// the conversion fails.
+	if n == 0 && hasPath {
+		return "", ErrDiscardZeroWithPath
 	}
 
-	return pathPrefix
+	// If it contains a positive discard item, the path component is prefixed
+	// by as many "../" components as the discard value minus one indicates.
+	if n > 0 {
+		return strings.Repeat("../", int(n-1)), nil
+	}
+
+	return "", nil
 }
 
 func (o *Discard) Set(v interface{}) error {
@@ -94,12 +93,9 @@ func (o *Discard) Set(v interface{}) error {
 		if !t {
 			return errors.New("discard cannot be false")
 		}
-		o.val = t
+		o.SetAll()
 	case uint64: // 0..127
-		if t > 127 {
-			return fmt.Errorf("discard must be in range 0..127, got %d", t)
-		}
-		o.val = t
+		return o.SetCount(t)
 	default:
 		return fmt.Errorf("unknown discard type: %T", t)
 	}
@@ -0,0 +1,83 @@
+package href
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotAbsoluteBase is returned by ResolveReference when the base is not an
+// absolute CRI
+var ErrNotAbsoluteBase = errors.New("base is not an absolute CRI")
+
+// ErrNetworkPathNoAuthority is returned by Parse (as the Kind of a *ParseError)
+// when a null scheme is not followed by an authority
+var ErrNetworkPathNoAuthority = errors.New("null scheme must be followed by an authority (network-path reference)")
+
+// Errors returned by ToURI when a CRI reference cannot be converted to a URI
+// reference (§6.1 of href-09)
+var (
+	ErrDiscardZeroWithPath     = errors.New("discard is 0 and the path item is present")
+	ErrPathNotAbEmpty          = errors.New("authority is present but path is not path-abempty")
+	ErrPathNotAbsRootlessEmpty = errors.New("authority is not present but scheme is and path is not absolute, rootless or empty")
+	ErrPathNotAbsNoSchemeEmpty = errors.New("authority and scheme not present and path is not absolute, noscheme or empty")
+)
+
+// Errors returned by Parse, wrapped in a *ParseError, to tell which constraint
+// the CRI reference violates.  Use errors.Is to test for them.
+var (
+	ErrInvalidCBOR      = errors.New("not a well-formed CBOR array")
+	ErrBadDiscard       = errors.New("bad discard")
+	ErrBadScheme        = errors.New("bad scheme")
+	ErrBadAuthority     = errors.New("bad authority")
+	ErrPortRange        = errors.New("port number must be in range 0..65535")
+	ErrBadPath          = errors.New("bad path")
+	ErrBadQuery         = errors.New("bad query")
+	ErrBadFragment      = errors.New("bad fragment")
+	ErrTrailingElements = errors.New("spurious trailing elements")
+	ErrNotDeterministic = errors.New("not in core deterministic encoding")
+)
+
+// Sections of a CRI reference, as reported by ParseError
+const (
+	SectionCRI       = "cri"
+	SectionDiscard   = "discard"
+	SectionScheme    = "scheme"
+	SectionAuthority = "authority"
+	SectionPath      = "path"
+	SectionQuery     = "query"
+	SectionFragment  = "fragment"
+)
+
+// ParseError is returned by Parse when a CRI reference cannot be decoded.  It
+// records the section and the index in the CRI array where decoding failed.
+// errors.Is matches both its Kind and the errors wrapped by Err.
+type ParseError struct {
+	// Kind is one of the Err* sentinels
+	Kind error
+	// Section is one of the Section* constants
+	Section string
+	// Index is the position of the offending element in the CRI array, or -1
+	// if the CRI reference is not an array
+	Index int
+	// Err describes the failure
+	Err error
+}
+
+func newParseError(kind error, section string, index int, err error) *ParseError {
+	return &ParseError{Kind: kind, Section: section, Index: index, Err: err}
+}
+
+func (e *ParseError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("%s: %v", e.Section, e.Err)
+	}
+	return fmt.Sprintf("%s (index %d): %v", e.Section, e.Index, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ParseError) Is(target error) bool {
+	return target == e.Kind
+}
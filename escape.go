@@ -0,0 +1,65 @@
+package href
+
+import "strings"
+
+const upperhex = "0123456789ABCDEF"
+
+// unreserved = ALPHA / DIGIT / "-" / "." / "_" / "~"
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// sub-delims = "!" / "$" / "&" / "'" / "(" / ")" / "*" / "+" / "," / ";" / "="
+func isSubDelim(c byte) bool {
+	return strings.IndexByte("!$&'()*+,;=", c) != -1
+}
+
+// reg-name = *( unreserved / pct-encoded / sub-delims )
+func isHostChar(c byte) bool {
+	return isUnreserved(c) || isSubDelim(c)
+}
+
+// a dot in a host-name label must be percent-encoded to tell it apart from
+// the label separator
+func isLabelChar(c byte) bool {
+	return c != '.' && isHostChar(c)
+}
+
+// userinfo = *( unreserved / pct-encoded / sub-delims / ":" )
+func isUserinfoChar(c byte) bool {
+	return isUnreserved(c) || isSubDelim(c) || c == ':'
+}
+
+// pchar = unreserved / pct-encoded / sub-delims / ":" / "@"
+func isPathChar(c byte) bool {
+	return isUnreserved(c) || isSubDelim(c) || c == ':' || c == '@'
+}
+
+// query = *( pchar / "/" / "?" ), minus the "&" that separates query items
+func isQueryItemChar(c byte) bool {
+	return c != '&' && (isPathChar(c) || c == '/' || c == '?')
+}
+
+// fragment = *( pchar / "/" / "?" )
+func isFragmentChar(c byte) bool {
+	return isPathChar(c) || c == '/' || c == '?'
+}
+
+// pctEncode percent-encodes any byte in s for which allowed returns false
+func pctEncode(s string, allowed func(byte) bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if allowed(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(upperhex[c>>4])
+		b.WriteByte(upperhex[c&15])
+	}
+
+	return b.String()
+}
@@ -4,10 +4,16 @@ import "fmt"
 
 type Fragment struct {
 	val *string
+	// pet holds the elements of the fragment if it was read as a PET
+	pet pet
 }
 
+// String returns the percent-encoded URI fragment component
 func (o Fragment) String() string {
-	return o.Get()
+	if o.pet != nil {
+		return o.pet.pctEncode(isFragmentChar)
+	}
+	return pctEncode(o.Get(), isFragmentChar)
 }
 
 func (o Fragment) IsSet() bool {
@@ -21,15 +27,52 @@ func (o Fragment) Get() string {
 	return ""
 }
 
+func (o Fragment) clone() Fragment {
+	if !o.IsSet() {
+		return Fragment{}
+	}
+	s := *o.val
+	return Fragment{val: &s, pet: o.pet}
+}
+
 func (o *Fragment) Reset() {
 	o.val = nil
+	o.pet = nil
 }
 
+// Set sets the fragment from its CBOR decoded form: a string, or the elements
+// of a percent-encoded text (PET), strings and []byte.  A string that is not
+// valid UTF-8 is encoded as a PET by ToCBOR.
 func (o *Fragment) Set(v interface{}) error {
-	if s, ok := v.(string); ok {
-		o.val = &s
-		return nil
+	s, p, ok, err := textOrPETFromValue(v)
+	if !ok {
+		return fmt.Errorf("unknown type for fragment: %T", v)
+	}
+	if err != nil {
+		return err
+	}
+
+	o.val = &s
+	o.pet = p
+
+	return nil
+}
+
+// setValue sets the fragment to s, which need not be valid UTF-8
+func (o *Fragment) setValue(s string) {
+	o.val = &s
+	o.pet = nil
+}
+
+// toCBOR returns the fragment encoded according to ver, or nil if unset
+func (o Fragment) toCBOR(ver Version) (interface{}, error) {
+	if !o.IsSet() {
+		return nil, nil
+	}
+
+	if o.pet != nil {
+		return o.pet.toCBOR(ver)
 	}
 
-	return fmt.Errorf("unknown type for fragment: %T", v)
+	return textOrPETToCBOR(*o.val, ver)
 }
@@ -0,0 +1,441 @@
+package href
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// uriRE splits a URI reference into its components (RFC3986, Appendix B)
+var uriRE = regexp.MustCompile(`^(?:([^:/?#]+):)?(?://([^/?#]*))?([^?#]*)(?:\?([^#]*))?(?:#(.*))?$`)
+
+// uriReference holds the components of a URI reference in their
+// percent-encoded form.  An authority, query or fragment may be present and
+// empty (e.g., "coap:///a", "coap://h?", "coap://h#").
+type uriReference struct {
+	scheme       string
+	authority    string
+	path         string
+	query        string
+	fragment     string
+	hasAuthority bool
+	hasQuery     bool
+	hasFragment  bool
+}
+
+func splitURI(uri string) (*uriReference, error) {
+	for i := 0; i < len(uri); i++ {
+		if c := uri[i]; c < 0x20 || c == 0x7f {
+			return nil, fmt.Errorf("invalid control character in URI")
+		}
+	}
+
+	// every string matches, as all the components are optional
+	m := uriRE.FindStringSubmatchIndex(uri)
+
+	component := func(i int) (string, bool) {
+		if m[2*i] < 0 {
+			return "", false
+		}
+		return uri[m[2*i]:m[2*i+1]], true
+	}
+
+	var u uriReference
+
+	u.scheme, _ = component(1)
+	u.authority, u.hasAuthority = component(2)
+	u.path, _ = component(3)
+	u.query, u.hasQuery = component(4)
+	u.fragment, u.hasFragment = component(5)
+
+	return &u, nil
+}
+
+// FromURI converts a URI reference to a CRI reference by determining the
+// sections of the CRI reference from the components of the URI reference:
+//
+//   - the scheme is mapped to a scheme-id if one is known, otherwise it is
+//     carried as a scheme-name;
+//   - the userinfo, host and port are split into the authority, and a
+//     host-name into its labels at the dots that are not percent-encoded;
+//   - dot segments are removed from the path, which is then split into
+//     path segments (relative paths are mapped to a discard plus the remaining
+//     segments);
+//   - the query is split into query items at each "&";
+//   - all components are percent-decoded: a path segment, query item or
+//     fragment that percent-encodes a character that it could hold as is
+//     (e.g., "%3D") is set as a PET, whose byte strings are the
+//     percent-encoded runs.
+//
+// An empty query or fragment is kept, unlike a missing one.
+func FromURI(uri string) (*CRI, error) {
+	var cri CRI
+
+	u, err := splitURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.scheme != "" {
+		if err := fromURISchemeRules(&cri, u.scheme); err != nil {
+			return nil, err
+		}
+
+		if err := fromURIAbsolutePathRules(&cri, u); err != nil {
+			return nil, err
+		}
+	} else if u.hasAuthority {
+		// network-path reference
+		if err := fromURIAuthorityRules(&cri.Authority, u.authority); err != nil {
+			return nil, err
+		}
+
+		if u.path != "" {
+			if err := setPathSegments(&cri.Path, removeDotSegments(u.path)[1:]); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		if err := fromURIRelativePathRules(&cri, u.path); err != nil {
+			return nil, err
+		}
+	}
+
+	if u.hasQuery {
+		if err := fromURIQueryRules(&cri, u.query); err != nil {
+			return nil, err
+		}
+	}
+
+	if u.hasFragment {
+		if err := fromURIFragmentRules(&cri, u.fragment); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cri, nil
+}
+
+// FromURL converts the URI reference u, as serialized by u.String, to a CRI
+// reference: see FromURI.  Note that url.URL cannot carry an empty fragment,
+// unless in its Opaque field.
+func FromURL(u *url.URL) (*CRI, error) {
+	return FromURI(u.String())
+}
+
+func fromURISchemeRules(cri *CRI, scheme string) error {
+	scheme = strings.ToLower(scheme)
+
+	if id, ok := LookupSchemeID(scheme); ok {
+		return cri.Scheme.Set(id)
+	}
+
+	return cri.Scheme.Set(scheme)
+}
+
+func fromURIAbsolutePathRules(cri *CRI, u *uriReference) error {
+	if u.hasAuthority {
+		if err := fromURIAuthorityRules(&cri.Authority, u.authority); err != nil {
+			return err
+		}
+	} else if strings.HasPrefix(u.path, "/") {
+		cri.Authority.SetNull()
+	} else {
+		// A URI without authority and with a rootless or empty path (e.g.,
+		// "urn:ietf:rfc:7252")
+		cri.Authority.SetTrue()
+		if u.path == "" {
+			return nil
+		}
+		return setPathSegments(&cri.Path, u.path)
+	}
+
+	if u.path == "" {
+		return nil
+	}
+
+	return setPathSegments(&cri.Path, removeDotSegments(u.path)[1:])
+}
+
+// fromURIAuthorityRules sets a from the percent-encoded authority component:
+//
+//	authority = [ userinfo "@" ] host [ ":" port ]
+//	host      = IP-literal / IPv4address / reg-name
+func fromURIAuthorityRules(a *Authority, authority string) error {
+	var (
+		userinfo string
+		hasUser  bool
+		host     = authority
+		port     string
+	)
+
+	if i := strings.LastIndexByte(host, '@'); i != -1 {
+		userinfo, host, hasUser = host[:i], host[i+1:], true
+	}
+
+	if strings.HasPrefix(host, "[") {
+		i := strings.IndexByte(host, ']')
+		if i == -1 {
+			return fmt.Errorf("missing ']' in host %q", host)
+		}
+		host, port = host[:i+1], host[i+1:]
+		if port != "" && port[0] != ':' {
+			return fmt.Errorf("invalid characters after host %q", host)
+		}
+	} else if i := strings.LastIndexByte(host, ':'); i != -1 {
+		host, port = host[:i], host[i:]
+	}
+
+	if err := fromURIHostRules(&a.Host, host); err != nil {
+		return err
+	}
+
+	if port = strings.TrimPrefix(port, ":"); port != "" {
+		p, err := strconv.ParseUint(port, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid port %q: %w", port, err)
+		}
+		if err := a.Port.Set(p); err != nil {
+			return err
+		}
+	}
+
+	if hasUser {
+		s, err := url.PathUnescape(userinfo)
+		if err != nil {
+			return err
+		}
+		if err := a.Userinfo.Set(s); err != nil {
+			return err
+		}
+	}
+
+	a.IsNull = false
+	a.IsTrue = false
+
+	return nil
+}
+
+func fromURIHostRules(h *Host, host string) error {
+	// IP-literal, possibly with a zone-id (RFC6874)
+	if strings.HasPrefix(host, "[") {
+		addr := host[1 : len(host)-1]
+
+		var zone string
+		if i := strings.Index(addr, "%25"); i != -1 {
+			addr, zone = addr[:i], addr[i+3:]
+		}
+
+		ip := net.ParseIP(addr)
+		if ip == nil || !strings.Contains(addr, ":") {
+			return fmt.Errorf("invalid IP literal: %s", addr)
+		}
+
+		if err := h.Set([]byte(ip.To16())); err != nil {
+			return err
+		}
+
+		if zone != "" {
+			z, err := url.PathUnescape(zone)
+			if err != nil {
+				return err
+			}
+			return h.SetZone(z)
+		}
+
+		return nil
+	}
+
+	// IPv4address
+	if ip := net.ParseIP(host); ip != nil && !strings.Contains(host, ":") {
+		return h.Set([]byte(ip.To4()))
+	}
+
+	// reg-name, whose percent-encoded dots are part of a label
+	labels := strings.Split(host, ".")
+	dotted := true
+
+	for i, l := range labels {
+		s, err := url.PathUnescape(l)
+		if err != nil {
+			return err
+		}
+		labels[i] = s
+		dotted = dotted && !strings.Contains(s, ".")
+	}
+
+	if !dotted {
+		return h.SetLabels(labels)
+	}
+
+	h.SetName(strings.Join(labels, "."))
+
+	return nil
+}
+
+func fromURIRelativePathRules(cri *CRI, path string) error {
+	// the empty path (e.g., "", "?query", "#fragment") does not discard
+	// anything from the base
+	if path == "" {
+		return cri.Discard.Set(uint64(0))
+	}
+
+	// an absolute-path reference discards the whole base path
+	if path[0] == '/' {
+		if err := cri.Discard.Set(true); err != nil {
+			return err
+		}
+		return setPathSegments(&cri.Path, removeDotSegments(path)[1:])
+	}
+
+	// a relative-path reference replaces the last segment of the base path,
+	// plus one more segment for each leading ".." segment
+	var (
+		discard  uint64 = 1
+		segments []string
+	)
+
+	raw := strings.Split(path, "/")
+
+	for i, s := range raw {
+		last := i == len(raw)-1
+
+		switch s {
+		case ".":
+		case "..":
+			if len(segments) > 0 {
+				segments = segments[:len(segments)-1]
+			} else {
+				discard++
+			}
+		default:
+			segments = append(segments, s)
+			continue
+		}
+
+		// a trailing dot segment leaves an empty last segment behind
+		if last {
+			segments = append(segments, "")
+		}
+	}
+
+	if err := cri.Discard.Set(discard); err != nil {
+		return err
+	}
+
+	return setPathSegments(&cri.Path, strings.Join(segments, "/"))
+}
+
+func fromURIQueryRules(cri *CRI, rawQuery string) error {
+	for _, item := range strings.Split(rawQuery, "&") {
+		s, p, err := pctDecode(item, isQueryItemChar)
+		if err != nil {
+			return err
+		}
+		cri.Query.appendValue(s, p)
+	}
+
+	return nil
+}
+
+func fromURIFragmentRules(cri *CRI, rawFragment string) error {
+	s, p, err := pctDecode(rawFragment, isFragmentChar)
+	if err != nil {
+		return err
+	}
+
+	cri.Fragment.val = &s
+	cri.Fragment.pet = p
+
+	return nil
+}
+
+// setPathSegments splits the escaped path p into its segments, percent-decodes
+// them and appends them to dst
+func setPathSegments(dst *Path, p string) error {
+	for _, segment := range strings.Split(p, "/") {
+		s, parts, err := pctDecode(segment, isPathChar)
+		if err != nil {
+			return err
+		}
+		dst.appendValue(s, parts)
+	}
+
+	return nil
+}
+
+// pctDecode percent-decodes s, a path segment, query item or fragment.  If s
+// percent-encodes a character that allowed lets through (e.g., "%3D" in a
+// query item, which is not the same as "="), the elements of a PET are
+// returned too, so that ToURI percent-encodes it again: the percent-encoded
+// runs of s are its byte strings.
+func pctDecode(s string, allowed func(byte) bool) (string, pet, error) {
+	decoded, err := url.PathUnescape(s)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var (
+		p      pet
+		needed bool
+	)
+
+	for i := 0; i < len(s); {
+		j := i
+		if s[i] == '%' {
+			for j < len(s) && s[j] == '%' {
+				j += 3
+			}
+			b, _ := url.PathUnescape(s[i:j])
+			for k := 0; k < len(b); k++ {
+				needed = needed || allowed(b[k])
+			}
+			p = append(p, petPart{s: b, bytes: true})
+		} else {
+			for j < len(s) && s[j] != '%' {
+				j++
+			}
+			p = append(p, petPart{s: s[i:j], bytes: !utf8.ValidString(s[i:j])})
+		}
+		i = j
+	}
+
+	if !needed {
+		return decoded, nil, nil
+	}
+
+	return decoded, p, nil
+}
+
+// removeDotSegments implements the "remove_dot_segments" algorithm from
+// §5.2.4 of RFC3986 for an absolute path
+func removeDotSegments(path string) string {
+	var output []string
+
+	segments := strings.Split(path[1:], "/")
+
+	for i, s := range segments {
+		last := i == len(segments)-1
+
+		switch s {
+		case ".":
+		case "..":
+			if len(output) > 0 {
+				output = output[:len(output)-1]
+			}
+		default:
+			output = append(output, s)
+			continue
+		}
+
+		if last {
+			output = append(output, "")
+		}
+	}
+
+	return "/" + strings.Join(output, "/")
+}
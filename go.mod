@@ -3,7 +3,6 @@ module github.com/thomas-fossati/href
 go 1.17
 
 require (
-	github.com/fxamacker/cbor v1.5.1
 	github.com/fxamacker/cbor/v2 v2.3.0
 	github.com/stretchr/testify v1.7.0
 )
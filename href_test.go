@@ -1,10 +1,17 @@
 package href
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/url"
+	"sync"
 	"testing"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -20,32 +27,184 @@ func MustHexDecode(s string) []byte {
 var BadTestVectors = []struct {
 	cri         []byte
 	expectedErr string
+	kind        error
+	section     string
+	index       int
 }{
 	{
 		// echo '["SCHEME"]' | diag2cbor.rb | xxd -p
 		cri:         MustHexDecode("8166534348454d45"),
-		expectedErr: "scheme-name SCHEME does not match scheme RE ([a-z][a-z0-9+.-]*)",
+		expectedErr: "scheme (index 0): scheme-name SCHEME does not match scheme RE ([a-z][a-z0-9+.-]*)",
+		kind:        ErrBadScheme,
+		section:     SectionScheme,
+		index:       0,
+	},
+	{
+		// the whole scheme-name must match, not a substring of it
+		// echo '["Coap"]' | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("8164436f6170"),
+		expectedErr: "scheme (index 0): scheme-name Coap does not match scheme RE ([a-z][a-z0-9+.-]*)",
+		kind:        ErrBadScheme,
+		section:     SectionScheme,
+		index:       0,
+	},
+	{
+		// echo '["coap:x"]' | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("8166636f61703a78"),
+		expectedErr: "scheme (index 0): scheme-name coap:x does not match scheme RE ([a-z][a-z0-9+.-]*)",
+		kind:        ErrBadScheme,
+		section:     SectionScheme,
+		index:       0,
 	},
 	{
 		// echo '{}' | diag2cbor.rb | xxd -p
 		cri:         MustHexDecode("a0"),
-		expectedErr: "cbor: cannot unmarshal map into Go value of type []interface {}",
+		expectedErr: "cri: expecting array, got map",
+		kind:        ErrInvalidCBOR,
+		section:     SectionCRI,
+		index:       -1,
 	},
 	{
 		// echo -n "[ h'01' ]" | diag2cbor.rb | xxd -p
 		cri:         MustHexDecode("814101"),
-		expectedErr: "expecting scheme or discard, got []uint8",
+		expectedErr: "scheme (index 0): expecting scheme or discard, got byte string",
+		kind:        ErrBadScheme,
+		section:     SectionScheme,
+		index:       0,
+	},
+	{
+		// echo '[200]' | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("8118c8"),
+		expectedErr: "discard (index 0): discard must be in range 0..127, got 200",
+		kind:        ErrBadDiscard,
+		section:     SectionDiscard,
+		index:       0,
+	},
+	{
+		// echo "[-1, [h'c0a80001', \"eth0\"]]" | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("82208244c0a800016465746830"),
+		expectedErr: "authority (index 1): zone-id requires an IPv6 host-ip",
+		kind:        ErrBadAuthority,
+		section:     SectionAuthority,
+		index:       1,
+	},
+	{
+		// echo '[-1, [false]]' | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("822081f4"),
+		expectedErr: "authority (index 1): missing userinfo after false marker",
+		kind:        ErrBadAuthority,
+		section:     SectionAuthority,
+		index:       1,
+	},
+	{
+		// echo '[-1, ["h", 70000]]' | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("82208261681a00011170"),
+		expectedErr: "authority (index 1): port number must be in range 0..65535: got 70000",
+		kind:        ErrPortRange,
+		section:     SectionAuthority,
+		index:       1,
+	},
+	{
+		// echo '[null]' | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("81f6"),
+		expectedErr: "authority (index 1): " + ErrNetworkPathNoAuthority.Error(),
+		kind:        ErrNetworkPathNoAuthority,
+		section:     SectionAuthority,
+		index:       1,
+	},
+	{
+		// echo '[null, null, ["a"]]' | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("83f6f6816161"),
+		expectedErr: "authority (index 1): " + ErrNetworkPathNoAuthority.Error(),
+		kind:        ErrNetworkPathNoAuthority,
+		section:     SectionAuthority,
+		index:       1,
+	},
+	{
+		// echo '[-1, null, ["a"], 1]' | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("8420f681616101"),
+		expectedErr: "query (index 3): unknown type: unsigned integer",
+		kind:        ErrBadQuery,
+		section:     SectionQuery,
+		index:       3,
+	},
+	{
+		// echo '[0, [], [], "f", 1]' | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("85008080616601"),
+		expectedErr: "cri (index 4): spurious trailing elements",
+		kind:        ErrTrailingElements,
+		section:     SectionCRI,
+		index:       4,
+	},
+	{
+		// echo '[0, ["a"]]' | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("8200816161"),
+		expectedErr: "path (index 1): " + ErrDiscardZeroWithPath.Error(),
+		kind:        ErrDiscardZeroWithPath,
+		section:     SectionPath,
+		index:       1,
+	},
+	{
+		// echo '[-1, true, ["", "a"]]' | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("8320f582606161"),
+		expectedErr: "path (index 2): " + ErrPathNotAbsRootlessEmpty.Error() + ": true authority followed by an empty segment",
+		kind:        ErrBadPath,
+		section:     SectionPath,
+		index:       2,
+	},
+	{
+		// echo '[-1, ["h"]]' | diag2cbor.rb | xxd -p, followed by 00
+		cri:         MustHexDecode("822081616800"),
+		expectedErr: "cri: 1 trailing bytes after the data item",
+		kind:        ErrInvalidCBOR,
+		section:     SectionCRI,
+		index:       -1,
+	},
+	{
+		// echo "[-1, [\"h\"], [h'ff']]" | diag2cbor.rb | xxd -p, with the byte
+		// string (41) turned into a text string (61)
+		cri:         MustHexDecode("83208161688161ff"),
+		expectedErr: "path (index 2): at offset 7: invalid UTF-8 text string",
+		kind:        ErrInvalidCBOR,
+		section:     SectionPath,
+		index:       2,
+	},
+	{
+		// [_ -1
+		cri:         MustHexDecode("9f20"),
+		expectedErr: "cri (index 1): at offset 2: truncated CBOR data item",
+		kind:        ErrInvalidCBOR,
+		section:     SectionCRI,
+		index:       1,
+	},
+	{
+		// echo '[-1, 32(["h"])]' | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("8220d820816168"),
+		expectedErr: "authority (index 1): unexpected authority type: tag",
+		kind:        ErrBadAuthority,
+		section:     SectionAuthority,
+		index:       1,
+	},
+	{
+		// echo '[-1, ["h", 1, 2]]' | diag2cbor.rb | xxd -p
+		cri:         MustHexDecode("82208361680102"),
+		expectedErr: "authority (index 1): wrong number of elements in authority: 3",
+		kind:        ErrBadAuthority,
+		section:     SectionAuthority,
+		index:       1,
 	},
 }
 
-// TODO(tho) since the "official" test vectors are supposed to cover all nominal
-// cases, we should repurpose this to explore corner cases.
-var GoodTestVectors = []struct {
+type GoodTestVector struct {
 	cri []byte
 	uri string
 	// set .criOut if round-tripping is supposed to produce a different value than .cri
 	criOut []byte
-}{
+}
+
+// TODO(tho) since the "official" test vectors are supposed to cover all nominal
+// cases, we should repurpose this to explore corner cases.
+var GoodTestVectors = []GoodTestVector{
 	{
 		// echo '[]' | diag2cbor.rb | xxd -p
 		cri: MustHexDecode("80"),
@@ -59,47 +218,48 @@ var GoodTestVectors = []struct {
 		criOut: MustHexDecode("80"),
 	},
 	{
+		// echo "[-2, [h'c0a80061']]" | diag2cbor.rb | xxd -p
 		cri: MustHexDecode("82218144c0a80061"),
 		uri: "coaps://192.168.0.97",
 	},
 	{
-		// echo '["coap+tcp", ["acme.example", 5683]]' | diag2cbor.rb | xxd -p
-		cri: MustHexDecode("8268636f61702b746370826c61636d652e6578616d706c65191633"),
+		// echo '["coap+tcp", ["acme", "example", 5683]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8268636f61702b746370836461636d65676578616d706c65191633"),
 		uri: "coap+tcp://acme.example:5683",
 	},
 	{
-		// echo '["coap+tcp", ["acme.example", 5683], ["a", "b", "c"]]' | diag2cbor.rb | xxd -p
-		cri: MustHexDecode("8368636f61702b746370826c61636d652e6578616d706c6519163383616161626163"),
+		// echo '["coap+tcp", ["acme", "example", 5683], ["a", "b", "c"]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8368636f61702b746370836461636d65676578616d706c6519163383616161626163"),
 		uri: "coap+tcp://acme.example:5683/a/b/c",
 	},
 	{
-		// echo '[-1, ["acme.example"]]' | diag2cbor.rb | xxd -p
-		cri: MustHexDecode("8220816c61636d652e6578616d706c65"),
+		// echo '[-1, ["acme", "example"]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8220826461636d65676578616d706c65"),
 		uri: "coap://acme.example",
 	},
 	{
-		// echo '[-1, ["acme.example"], null, ["a=b", "c=d"]]' | diag2cbor.rb | xxd -p
-		cri: MustHexDecode("8420816c61636d652e6578616d706c65f68263613d6263633d64"),
+		// echo '[-1, ["acme", "example"], null, ["a=b", "c=d"]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8420826461636d65676578616d706c65f68263613d6263633d64"),
 		uri: "coap://acme.example?a=b&c=d",
 	},
 	{
-		// echo '[-2, ["acme.example"], null, null, "fragment"]' | diag2cbor.rb | xxd -p
-		cri: MustHexDecode("8521816c61636d652e6578616d706c65f6f668667261676d656e74"),
+		// echo '[-2, ["acme", "example"], null, null, "fragment"]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8521826461636d65676578616d706c65f6f668667261676d656e74"),
 		uri: "coaps://acme.example#fragment",
 	},
 	{
-		// echo '[-3, ["acme.example"]]' | diag2cbor.rb | xxd -p
-		cri: MustHexDecode("8222816c61636d652e6578616d706c65"),
+		// echo '[-3, ["acme", "example"]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8222826461636d65676578616d706c65"),
 		uri: "http://acme.example",
 	},
 	{
-		// echo '[-4, ["acme.example"]]' | diag2cbor.rb | xxd -p
-		cri: MustHexDecode("8223816c61636d652e6578616d706c65"),
+		// echo '[-4, ["acme", "example"]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8223826461636d65676578616d706c65"),
 		uri: "https://acme.example",
 	},
 	{
-		// echo '[-5, ["acme.example"]]' | diag2cbor.rb | xxd -p
-		cri: MustHexDecode("8224816c61636d652e6578616d706c65"),
+		// echo '[-5, ["acme", "example"]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8224826461636d65676578616d706c65"),
 		uri: "urn://acme.example",
 	},
 	{
@@ -117,18 +277,134 @@ var GoodTestVectors = []struct {
 		cri: MustHexDecode("81f5"),
 		uri: "/",
 	},
+	{
+		// echo '[-1, ["acme", "example"], ["a/b", "c%d"], ["x&y", "z#"], "f%"]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8520826461636d65676578616d706c658263612f62636325648263782679627a23626625"),
+		uri: "coap://acme.example/a%2Fb/c%25d?x%26y&z%23#f%25",
+	},
+	{
+		// echo '[1, ["a:b"]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("82018163613a62"),
+		uri: "./a:b",
+	},
+	{
+		// echo '[-1, ["hé"]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8220816368c3a9"),
+		uri: "coap://h%C3%A9",
+	},
+	{
+		// echo "[-1, [h'fe800000000000000000000000000001', 5683]]" | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("82208250fe800000000000000000000000000001191633"),
+		uri: "coap://[fe80::1]:5683",
+	},
+	{
+		// echo "[-1, [h'fe800000000000000000000000000001', \"eth0\", 5683], [\"a\"]]" | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("83208350fe8000000000000000000000000000016465746830191633816161"),
+		uri: "coap://[fe80::1%25eth0]:5683/a",
+	},
+	{
+		// echo "[-1, [h'00000000000000000000ffffc0a80001']]" | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8220815000000000000000000000ffffc0a80001"),
+		uri: "coap://[::ffff:192.168.0.1]",
+	},
+	{
+		// echo '[-1, [false, "user:pw", "acme", "example", 5683]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("822085f467757365723a70776461636d65676578616d706c65191633"),
+		uri: "coap://user:pw@acme.example:5683",
+	},
+	{
+		// echo "[-1, [\"a\"], [[\"a\", h'ff', \"b\"]], [[h'fe']], [\"x\", h'80']]" | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("85208161618183616141ff6162818141fe8261784180"),
+		uri: "coap://a/a%FFb?%FE#x%80",
+	},
+	{
+		// byte string elements are percent-encoded even if valid UTF-8
+		// echo "[-1, [\"h\"], [[\"a\", h'2f']], [[\"k\", h'3d26'], \"v\"], [h'23']]" | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("852081616881826161412f8282616b423d266176814123"),
+		uri: "coap://h/a%2F?k%3D%26&v#%23",
+	},
+	{
+		// echo '[-1, ["h"], null, [""]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8420816168f68160"),
+		uri: "coap://h?",
+	},
+	{
+		// echo '[-1, ["h"], null, null, ""]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8520816168f6f660"),
+		uri: "coap://h#",
+	},
+	{
+		// echo '[-1, ["h"], ["a"], [""], ""]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8520816168816161816060"),
+		uri: "coap://h/a?#",
+	},
+	{
+		// echo '[0, null, null, ""]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8400f6f660"),
+		uri: "#",
+	},
+	{
+		// echo '[null, ["acme", "example", 5683], ["a"]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("83f6836461636d65676578616d706c65191633816161"),
+		uri: "//acme.example:5683/a",
+	},
+}
+
+// GoodTestVectorsHref09 use the href-09 wire format
+var GoodTestVectorsHref09 = []GoodTestVector{
+	{
+		// echo '["coap+tcp", ["acme.example", 5683]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8268636f61702b746370826c61636d652e6578616d706c65191633"),
+		uri: "coap+tcp://acme.example:5683",
+	},
+	{
+		// echo '["coap+tcp", ["acme.example", 5683], ["a", "b", "c"]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8368636f61702b746370826c61636d652e6578616d706c6519163383616161626163"),
+		uri: "coap+tcp://acme.example:5683/a/b/c",
+	},
+	{
+		// echo '[-1, ["acme.example"]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8220816c61636d652e6578616d706c65"),
+		uri: "coap://acme.example",
+	},
+	{
+		// echo '[-1, ["acme.example"], null, ["a=b", "c=d"]]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8420816c61636d652e6578616d706c65f68263613d6263633d64"),
+		uri: "coap://acme.example?a=b&c=d",
+	},
+	{
+		// echo '[-2, ["acme.example"], null, null, "fragment"]' | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("8521816c61636d652e6578616d706c65f6f668667261676d656e74"),
+		uri: "coaps://acme.example#fragment",
+	},
+	{
+		// echo "[-2, [h'c0a80061']]" | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("82218144c0a80061"),
+		uri: "coaps://192.168.0.97",
+	},
+	{
+		// echo "[-1, [h'fe800000000000000000000000000001', 5683]]" | diag2cbor.rb | xxd -p
+		cri: MustHexDecode("82208250fe800000000000000000000000000001191633"),
+		uri: "coap://[fe80::1]:5683",
+	},
 }
 
 func TestCRI_full_circle(t *testing.T) {
-	for i, tv := range GoodTestVectors {
-		decoded, err := Parse(tv.cri)
+	testFullCircle(t, GoodTestVectors)
+	testFullCircle(t, GoodTestVectors, WithVersion(Href16))
+	testFullCircle(t, GoodTestVectorsHref09, WithVersion(Href09))
+}
+
+func testFullCircle(t *testing.T, tvs []GoodTestVector, opts ...Option) {
+	for i, tv := range tvs {
+		decoded, err := Parse(tv.cri, opts...)
 		assert.NoError(t, err, "test case at index %d failed decoding", i)
 
 		uri, err := decoded.ToURI()
 		assert.NoError(t, err, "test case at index %d failed translation to URI", i)
 		assert.Equal(t, tv.uri, uri.String())
 
-		encoded, err := decoded.ToCBOR()
+		encoded, err := decoded.ToCBOR(opts...)
 		assert.NoError(t, err, "test case at index %d failed encoding", i)
 		if tv.criOut != nil {
 			assert.Equal(t, tv.criOut, encoded)
@@ -138,30 +414,464 @@ func TestCRI_full_circle(t *testing.T) {
 	}
 }
 
+func TestCRI_ToURI_reparse(t *testing.T) {
+	for i, tv := range GoodTestVectors {
+		decoded, err := Parse(tv.cri)
+		require.NoError(t, err, "test case at index %d failed decoding", i)
+
+		uri, err := decoded.ToURI()
+		require.NoError(t, err, "test case at index %d failed translation to URI", i)
+
+		reparsed, err := url.Parse(uri.String())
+		require.NoError(t, err, "test case at index %d failed parsing URI", i)
+
+		// e.g. an empty fragment, which only an opaque URL can carry, and
+		// which url.Parse drops
+		if uri.Opaque != "" {
+			continue
+		}
+
+		assert.Equal(t, uri.ForceQuery, reparsed.ForceQuery, "test case at index %d", i)
+
+		assert.Equal(t, uri.Scheme, reparsed.Scheme, "test case at index %d", i)
+		assert.Equal(t, uri.Host, reparsed.Host, "test case at index %d", i)
+		assert.Equal(t, uri.EscapedPath(), reparsed.EscapedPath(), "test case at index %d", i)
+		assert.Equal(t, uri.RawQuery, reparsed.RawQuery, "test case at index %d", i)
+		assert.Equal(t, uri.Fragment, reparsed.Fragment, "test case at index %d", i)
+	}
+}
+
+func TestHost_labels(t *testing.T) {
+	// echo '[-1, ["my.printer", "_ipp", "_tcp", "local"], ["a"]]' | diag2cbor.rb | xxd -p
+	cri := MustHexDecode("8320846a6d792e7072696e746572645f697070645f746370656c6f63616c816161")
+
+	decoded, err := Parse(cri)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"my.printer", "_ipp", "_tcp", "local"}, decoded.Authority.Host.Labels())
+
+	uri, err := decoded.ToURI()
+	require.NoError(t, err)
+	assert.Equal(t, "coap://my%2Eprinter._ipp._tcp.local/a", uri.String())
+
+	encoded, err := decoded.ToCBOR()
+	require.NoError(t, err)
+	assert.Equal(t, cri, encoded)
+
+	// the single text form is split at the dots
+	var h Host
+	require.NoError(t, h.Set("acme.example"))
+	assert.Equal(t, []string{"acme", "example"}, h.Labels())
+}
+
+func TestCRI_version_ko(t *testing.T) {
+	// echo '[-1, [false, "user:pw", "acme", "example", 5683]]' | diag2cbor.rb | xxd -p
+	userinfo := MustHexDecode("822085f467757365723a70776461636d65676578616d706c65191633")
+
+	_, err := Parse(userinfo, WithVersion(Href09))
+	assert.EqualError(t, err, "authority (index 1): userinfo not supported in href-09")
+
+	c, err := Parse(userinfo)
+	require.NoError(t, err)
+
+	_, err = c.ToCBOR(WithVersion(Href09))
+	assert.EqualError(t, err, "userinfo not supported in href-09")
+
+	var h Host
+	require.NoError(t, h.SetLabels([]string{"my.printer", "local"}))
+
+	c = &CRI{}
+	require.NoError(t, c.Scheme.Set(int64(-1)))
+	c.Authority.Host = h
+
+	_, err = c.ToCBOR(WithVersion(Href09))
+	assert.EqualError(t, err, `host-name label "my.printer" contains a dot, not supported in href-09`)
+
+	// echo "[-1, [\"a\"], [[h'ff']]]" | diag2cbor.rb | xxd -p
+	_, err = Parse(MustHexDecode("8320816161818141ff"), WithVersion(Href09))
+	assert.EqualError(t, err, "path (index 2): percent-encoded text not supported in href-09")
+
+	_, err = Parse(MustHexDecode("80"), WithVersion(Version(7)))
+	assert.EqualError(t, err, "unsupported version: href-07")
+}
+
+func TestParse_indefinite(t *testing.T) {
+	// [_ -1, [_ (_ "a"), 5695], [_ [_ (_ "c", "a"), h'e9']]]
+	cri, err := Parse(MustHexDecode("9f209f7f6161ff19163fff9f9f7f61636161ff41e9ffffff"))
+	require.NoError(t, err)
+
+	actual, err := cri.ToCBOR()
+	require.NoError(t, err)
+	// echo "[-1, [\"a\", 5695], [[\"ca\", h'e9']]]" | diag2cbor.rb | xxd -p
+	assert.Equal(t, MustHexDecode("832082616119163f818262636141e9"), actual)
+}
+
 func TestCRI_ko(t *testing.T) {
-	for _, tv := range BadTestVectors {
+	for i, tv := range BadTestVectors {
 		_, err := Parse(tv.cri)
-		assert.EqualError(t, err, tv.expectedErr)
+		assert.EqualError(t, err, tv.expectedErr, "test case at index %d", i)
+		assert.ErrorIs(t, err, tv.kind, "test case at index %d", i)
+
+		var pe *ParseError
+		require.ErrorAs(t, err, &pe, "test case at index %d", i)
+		assert.Equal(t, tv.section, pe.Section, "test case at index %d", i)
+		assert.Equal(t, tv.index, pe.Index, "test case at index %d", i)
+	}
+
+	// what Parse accepts, ToCBOR encodes: cbor.Unmarshal applies the same rules
+	var c CRI
+	// echo '[0, ["a"]]' | diag2cbor.rb | xxd -p
+	assert.ErrorIs(t, cbor.Unmarshal(MustHexDecode("8200816161"), &c), ErrDiscardZeroWithPath)
+}
+
+func TestCRI_ResolveReference(t *testing.T) {
+	// echo '[-1, ["acme", "example"], ["x", "y"], ["q"]]' | diag2cbor.rb | xxd -p
+	base := MustHexDecode("8420826461636d65676578616d706c658261786179816171")
+
+	tvs := []struct {
+		ref      []byte
+		resolved []byte
+	}{
+		{
+			// echo '[null, ["other"], ["a"]]' | diag2cbor.rb | xxd -p
+			ref: MustHexDecode("83f681656f74686572816161"),
+			// echo '[-1, ["other"], ["a"]]' | diag2cbor.rb | xxd -p
+			resolved: MustHexDecode("832081656f74686572816161"),
+		},
+		{
+			// echo '[2, ["b"]]' | diag2cbor.rb | xxd -p
+			ref: MustHexDecode("8202816162"),
+			// echo '[-1, ["acme", "example"], ["b"]]' | diag2cbor.rb | xxd -p
+			resolved: MustHexDecode("8320826461636d65676578616d706c65816162"),
+		},
+		{
+			// echo '[0, null, ["r"]]' | diag2cbor.rb | xxd -p
+			ref: MustHexDecode("8300f6816172"),
+			// echo '[-1, ["acme", "example"], ["x", "y"], ["r"]]' | diag2cbor.rb | xxd -p
+			resolved: MustHexDecode("8420826461636d65676578616d706c658261786179816172"),
+		},
+	}
+
+	baseCRI, err := Parse(base)
+	require.NoError(t, err)
+
+	for i, tv := range tvs {
+		ref, err := Parse(tv.ref)
+		require.NoError(t, err, "test case at index %d failed decoding", i)
+
+		resolved, err := baseCRI.ResolveReference(ref)
+		require.NoError(t, err, "test case at index %d failed resolution", i)
+
+		got, err := resolved.ToCBOR()
+		require.NoError(t, err, "test case at index %d failed encoding", i)
+		assert.Equal(t, tv.resolved, got, "test case at index %d: got %x", i, got)
+	}
+
+	// RFC3986 §5.4.2, abnormal examples, plus a discard beyond a path made of
+	// one empty segment
+	uris := []struct {
+		base     string
+		ref      string
+		resolved string
+	}{
+		{base: "coap://a/b/c/d;p?q", ref: "../../../g", resolved: "coap://a/g"},
+		{base: "coap://a/b/c/d;p?q", ref: "../../../../g", resolved: "coap://a/g"},
+		{base: "coap://a/b/c/d;p?q", ref: "/./g", resolved: "coap://a/g"},
+		{base: "coap://a/b/c/d;p?q", ref: "/../g", resolved: "coap://a/g"},
+		{base: "coap://a/b/c/d;p?q", ref: "g.", resolved: "coap://a/b/c/g."},
+		{base: "coap://a/b/c/d;p?q", ref: ".g", resolved: "coap://a/b/c/.g"},
+		{base: "coap://a/b/c/d;p?q", ref: "g..", resolved: "coap://a/b/c/g.."},
+		{base: "coap://a/b/c/d;p?q", ref: "..g", resolved: "coap://a/b/c/..g"},
+		{base: "coap://a/b/c/d;p?q", ref: "./../g", resolved: "coap://a/b/g"},
+		{base: "coap://a/b/c/d;p?q", ref: "./g/.", resolved: "coap://a/b/c/g/"},
+		{base: "coap://a/b/c/d;p?q", ref: "g/./h", resolved: "coap://a/b/c/g/h"},
+		{base: "coap://a/b/c/d;p?q", ref: "g/../h", resolved: "coap://a/b/c/h"},
+		{base: "coap://a/b/c/d;p?q", ref: "g;x=1/./y", resolved: "coap://a/b/c/g;x=1/y"},
+		{base: "coap://a/b/c/d;p?q", ref: "g;x=1/../y", resolved: "coap://a/b/c/y"},
+		{base: "coap://a/b/c/d;p?q", ref: "g?y/./x", resolved: "coap://a/b/c/g?y/./x"},
+		{base: "coap://a/b/c/d;p?q", ref: "g?y/../x", resolved: "coap://a/b/c/g?y/../x"},
+		{base: "coap://a/b/c/d;p?q", ref: "g#s/./x", resolved: "coap://a/b/c/g#s/./x"},
+		{base: "coap://a/b/c/d;p?q", ref: "g#s/../x", resolved: "coap://a/b/c/g#s/../x"},
+		{base: "coap://h/", ref: "../g", resolved: "coap://h/g"},
+	}
+
+	for _, tv := range uris {
+		baseCRI, err := FromURI(tv.base)
+		require.NoError(t, err, tv.base)
+
+		ref, err := FromURI(tv.ref)
+		require.NoError(t, err, tv.ref)
+
+		resolved, err := baseCRI.ResolveReference(ref)
+		require.NoError(t, err, tv.ref)
+
+		got, err := resolved.ToURI()
+		require.NoError(t, err, tv.ref)
+		assert.Equal(t, tv.resolved, got.String(), "%s against %s", tv.ref, tv.base)
+	}
+}
+
+func TestCRI_ResolveReference_ko(t *testing.T) {
+	// echo '[-1, ["acme", "example"], ["x", "y"], ["q"]]' | diag2cbor.rb | xxd -p
+	base, err := Parse(MustHexDecode("8420826461636d65676578616d706c658261786179816171"))
+	require.NoError(t, err)
+
+	// echo '[2, ["b"]]' | diag2cbor.rb | xxd -p
+	ref, err := Parse(MustHexDecode("8202816162"))
+	require.NoError(t, err)
+
+	_, err = ref.ResolveReference(ref)
+	assert.ErrorIs(t, err, ErrNotAbsoluteBase)
+
+	_, err = base.ResolveReference(&CRI{})
+	assert.EqualError(t, err, "reference: neither an absolute CRI nor a relative reference")
+
+	bad := ref.Clone()
+	bad.Discard.val = "two"
+	_, err = base.ResolveReference(bad)
+	assert.EqualError(t, err, "reference: unknown discard type: string")
+
+	bad = base.Clone()
+	_ = bad.Discard.Set(true)
+	_, err = bad.ResolveReference(ref)
+	assert.EqualError(t, err, "base: discard cannot be combined with scheme or authority")
+}
+
+func TestCRI_RelativeTo(t *testing.T) {
+	// echo '[-1, ["acme", "example"], ["x", "y"], ["q"]]' | diag2cbor.rb | xxd -p
+	base, err := Parse(MustHexDecode("8420826461636d65676578616d706c658261786179816171"))
+	require.NoError(t, err)
+
+	tvs := []struct {
+		target   []byte
+		expected []byte
+	}{
+		{
+			// echo '[-1, ["acme", "example"], ["x", "z"]]' | diag2cbor.rb | xxd -p
+			target: MustHexDecode("8320826461636d65676578616d706c65826178617a"),
+			// echo '[1, ["z"]]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("820181617a"),
+		},
+		{
+			// echo '[-1, ["acme", "example"], ["x", "y"], ["q"], "f"]' | diag2cbor.rb | xxd -p
+			target: MustHexDecode("8520826461636d65676578616d706c6582617861798161716166"),
+			// echo '[0, null, null, "f"]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("8400f6f66166"),
+		},
+		{
+			// echo '[-1, ["acme", "example"], ["x", "y"], ["q"]]' | diag2cbor.rb | xxd -p
+			target: MustHexDecode("8420826461636d65676578616d706c658261786179816171"),
+			// echo '[]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("80"),
+		},
+		{
+			// echo '[-1, ["acme", "example"], ["a"]]' | diag2cbor.rb | xxd -p
+			target: MustHexDecode("8320826461636d65676578616d706c65816161"),
+			// echo '[2, ["a"]]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("8202816161"),
+		},
+		{
+			// echo '[-1, ["acme", "example"], ["x", "y", "z"], ["r"]]' | diag2cbor.rb | xxd -p
+			target: MustHexDecode("8420826461636d65676578616d706c658361786179617a816172"),
+			// a discard of 0 cannot be followed by a path
+			// echo '[1, ["y", "z"], ["r"]]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("8301826179617a816172"),
+		},
+		{
+			// echo '[-1, ["other"], ["a"]]' | diag2cbor.rb | xxd -p
+			target: MustHexDecode("832081656f74686572816161"),
+			// echo '[-1, ["other"], ["a"]]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("832081656f74686572816161"),
+		},
+		{
+			// echo '["http", ["acme", "example"], ["x", "y"]]' | diag2cbor.rb | xxd -p
+			target: MustHexDecode("836468747470826461636d65676578616d706c658261786179"),
+			// echo '["http", ["acme", "example"], ["x", "y"]]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("836468747470826461636d65676578616d706c658261786179"),
+		},
+	}
+
+	for i, tv := range tvs {
+		target, err := Parse(tv.target)
+		require.NoError(t, err, "test case at index %d failed decoding", i)
+
+		ref, err := target.RelativeTo(base)
+		require.NoError(t, err, "test case at index %d failed", i)
+
+		got, err := ref.ToCBOR()
+		require.NoError(t, err, "test case at index %d failed encoding", i)
+		assert.Equal(t, tv.expected, got, "test case at index %d: got %x", i, got)
+
+		resolved, err := base.ResolveReference(ref)
+		require.NoError(t, err, "test case at index %d failed resolution", i)
+
+		got, err = resolved.ToCBOR()
+		require.NoError(t, err, "test case at index %d failed encoding", i)
+		assert.Equal(t, tv.target, got, "test case at index %d: got %x", i, got)
 	}
+
+	_, err = base.RelativeTo(&CRI{})
+	assert.ErrorIs(t, err, ErrNotAbsoluteBase)
+}
+
+func mustFromURI(t *testing.T, uri string) *CRI {
+	c, err := FromURI(uri)
+	require.NoError(t, err)
+	return c
+}
+
+func mustParse(t *testing.T, hexCRI string) *CRI {
+	c, err := Parse(MustHexDecode(hexCRI))
+	require.NoError(t, err)
+	return c
+}
+
+func TestEqual(t *testing.T) {
+	tvs := []struct {
+		a, b  *CRI
+		equal bool
+	}{
+		{
+			a: mustFromURI(t, "coap://ACME.example:5683/a"),
+			// echo '[-1, ["acme", "example"], ["a"]]' | diag2cbor.rb | xxd -p
+			b:     mustParse(t, "8320826461636d65676578616d706c65816161"),
+			equal: true,
+		},
+		{
+			// echo '["coap", ["h"]]' | diag2cbor.rb | xxd -p
+			a: mustParse(t, "8264636f6170816168"),
+			// echo '[-1, ["h"]]' | diag2cbor.rb | xxd -p
+			b:     mustParse(t, "8220816168"),
+			equal: true,
+		},
+		{
+			a:     mustFromURI(t, "coap://[::ffff:192.168.0.1]"),
+			b:     mustFromURI(t, "coap://192.168.0.1"),
+			equal: true,
+		},
+		{
+			// echo '[]' | diag2cbor.rb | xxd -p
+			a: mustParse(t, "80"),
+			// echo '[0]' | diag2cbor.rb | xxd -p
+			b:     mustParse(t, "8100"),
+			equal: true,
+		},
+		{
+			a:     mustFromURI(t, "https://h:443"),
+			b:     mustFromURI(t, "https://h"),
+			equal: true,
+		},
+		{
+			a:     mustFromURI(t, "http://h:443"),
+			b:     mustFromURI(t, "http://h"),
+			equal: false,
+		},
+		{
+			a:     mustFromURI(t, "coap://h/a"),
+			b:     mustFromURI(t, "coap://h/b"),
+			equal: false,
+		},
+		{
+			a:     mustFromURI(t, "coap://h/a#f"),
+			b:     mustFromURI(t, "coap://h/a"),
+			equal: false,
+		},
+		{
+			// echo "[-1, [\"h\"], null, [[\"k\", h'3d26']]]" | diag2cbor.rb | xxd -p
+			a: mustParse(t, "8420816168f68182616b423d26"),
+			// echo '[-1, ["h"], null, ["k=&"]]' | diag2cbor.rb | xxd -p
+			b:     mustParse(t, "8420816168f681636b3d26"),
+			equal: false,
+		},
+		{
+			a: mustFromURI(t, "coap://h/caf%E9"),
+			// echo "[-1, [\"h\"], [[\"caf\", h'e9']]]" | diag2cbor.rb | xxd -p
+			b:     mustParse(t, "832081616881826363616641e9"),
+			equal: true,
+		},
+	}
+
+	for i, tv := range tvs {
+		assert.Equal(t, tv.equal, Equal(tv.a, tv.b), "test case at index %d", i)
+		assert.Equal(t, tv.equal, Equal(tv.b, tv.a), "test case at index %d", i)
+	}
+}
+
+func TestCRI_Normalize(t *testing.T) {
+	c := mustFromURI(t, "coap://ACME.example:5683/a")
+	c.Normalize()
+
+	got, err := c.ToCBOR()
+	require.NoError(t, err)
+	// echo '[-1, ["acme", "example"], ["a"]]' | diag2cbor.rb | xxd -p
+	assert.Equal(t, MustHexDecode("8320826461636d65676578616d706c65816161"), got)
+}
+
+func TestCRI_Clone(t *testing.T) {
+	// echo '[-1, [false, "u", "acme", "example", 5683], ["x", "y"], ["q"], "f"]' | diag2cbor.rb | xxd -p
+	cri := MustHexDecode("852085f461756461636d65676578616d706c6519163382617861798161716166")
+
+	orig, err := Parse(cri)
+	require.NoError(t, err)
+
+	c := orig.Clone()
+
+	c.Path.Append([]string{"z"})
+	c.Query.Reset()
+	require.NoError(t, c.Fragment.Set("g"))
+	require.NoError(t, c.Authority.Port.Set(uint64(5684)))
+	require.NoError(t, c.Authority.Userinfo.Set("v"))
+	c.Authority.Host.Get().([]string)[0] = "ACME"
+
+	got, err := orig.ToCBOR()
+	require.NoError(t, err)
+	assert.Equal(t, cri, got)
+}
+
+func TestCRI_ResolveReference_concurrent(t *testing.T) {
+	// echo '[-1, [false, "u", "acme", "example", 5683], ["x", "y"], ["q"], "f"]' | diag2cbor.rb | xxd -p
+	base, err := Parse(MustHexDecode("852085f461756461636d65676578616d706c6519163382617861798161716166"))
+	require.NoError(t, err)
+
+	// echo '[1, ["y", "z"]]' | diag2cbor.rb | xxd -p
+	ref, err := Parse(MustHexDecode("8201826179617a"))
+	require.NoError(t, err)
+
+	// echo '[-1, [false, "u", "acme", "example", 5683], ["x", "y", "z"]]' | diag2cbor.rb | xxd -p
+	expected := MustHexDecode("832085f461756461636d65676578616d706c651916338361786179617a")
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resolved, err := base.ResolveReference(ref)
+			if !assert.NoError(t, err) {
+				return
+			}
+			got, err := resolved.ToCBOR()
+			assert.NoError(t, err)
+			assert.Equal(t, expected, got)
+		}()
+	}
+
+	wg.Wait()
 }
 
 func Test_repo(t *testing.T) {
 	tests, err := LoadTests("./tests.json")
 	require.NoError(t, err)
 
-	// XXX(tho) workaround for missing deep copy in reference resolution
-	// baseCRI, err := Parse(MustHexDecode(tests.BaseCRI))
-	// NoError(t, err)
+	baseCRI, err := Parse(MustHexDecode(tests.BaseCRI), WithVersion(Href09))
+	require.NoError(t, err)
 
 	baseURI, err := url.Parse(tests.BaseURI)
 	require.NoError(t, err)
 
 	for i, tv := range tests.TestVectors {
-		baseCRI, err := Parse(MustHexDecode(tests.BaseCRI))
-		require.NoError(t, err)
-
 		// CBOR serialization (§5.1 of href-09)
-		c, err := Parse(MustHexDecode(tv.CRI))
+		c, err := Parse(MustHexDecode(tv.CRI), WithVersion(Href09))
 		require.NoError(t, err, "TC[%d] failed: parsing CRI", i)
 
 		// CRI to URL (§6.1 of href-09)
@@ -175,9 +885,1257 @@ func Test_repo(t *testing.T) {
 
 		// (§5.3 of href-09)
 		expected := MustHexDecode(tv.ResolvedCRI)
-		resolvedCRI := baseCRI.ResolveReference(c)
-		got, err := resolvedCRI.ToCBOR()
+		resolvedCRI, err := baseCRI.ResolveReference(c)
+		require.NoError(t, err, "TC[%d] failed: resolving CRI reference", i)
+		got, err := resolvedCRI.ToCBOR(WithVersion(Href09))
 		assert.NoError(t, err, "TC[%d] failed: resolving CRI reference", i)
 		assert.Equal(t, expected, got, "TC[%d] want: %x, got %x", i, expected, got)
 	}
 }
+
+func TestFromURI(t *testing.T) {
+	tvs := []struct {
+		uri string
+		cri []byte
+	}{
+		{
+			uri: "coap://acme.example",
+			// echo '[-1, ["acme", "example"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8220826461636d65676578616d706c65"),
+		},
+		{
+			uri: "coap+tcp://acme.example:5683/a/b/c",
+			// echo '[-7, ["acme", "example", 5683], ["a", "b", "c"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8326836461636d65676578616d706c6519163383616161626163"),
+		},
+		{
+			uri: "coaps://192.168.0.97",
+			// echo "[-2, [h'c0a80061']]" | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("82218144c0a80061"),
+		},
+		{
+			uri: "coap://acme.example/./x/../a%2Fb?a=b&c%26=d#frag%25",
+			// echo '[-1, ["acme", "example"], ["a/b"], ["a=b", "c&=d"], "frag%"]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8520826461636d65676578616d706c658163612f628263613d626463263d64656672616725"),
+		},
+		{
+			uri: "coap://[fe80::1%25eth0]:5683/a",
+			// echo "[-1, [h'fe800000000000000000000000000001', \"eth0\", 5683], [\"a\"]]" | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("83208350fe8000000000000000000000000000016465746830191633816161"),
+		},
+		{
+			uri: "coap://user:pw@acme.example:5683",
+			// echo '[-1, [false, "user:pw", "acme", "example", 5683]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("822085f467757365723a70776461636d65676578616d706c65191633"),
+		},
+		{
+			uri: "coap://a/%FF",
+			// echo "[-1, [\"a\"], [[h'ff']]]" | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8320816161818141ff"),
+		},
+		{
+			uri: "//acme.example:5683/a",
+			// echo '[null, ["acme", "example", 5683], ["a"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("83f6836461636d65676578616d706c65191633816161"),
+		},
+		{
+			uri: "urn:ietf:rfc:7252",
+			// echo '[-5, true, ["ietf:rfc:7252"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8324f5816d696574663a7266633a37323532"),
+		},
+		{
+			uri: "",
+			// echo '[]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("80"),
+		},
+		{
+			uri: "?q",
+			// echo '[0, null, ["q"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8300f6816171"),
+		},
+		{
+			uri: "/a/b",
+			// echo '[true, ["a", "b"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("82f58261616162"),
+		},
+		{
+			uri: "../../a",
+			// echo '[3, ["a"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8203816161"),
+		},
+		{
+			uri: "./a/../b/.",
+			// echo '[1, ["b", ""]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("820182616260"),
+		},
+		{
+			// "%3D" is not the same as "="
+			uri: "coap://h?k%3D%26",
+			// echo "[-1, [\"h\"], null, [[\"k\", h'3d26']]]" | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8420816168f68182616b423d26"),
+		},
+		{
+			uri: "coap://h?#",
+			// echo '[-1, ["h"], null, [""], ""]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8520816168f6816060"),
+		},
+	}
+
+	for i, tv := range tvs {
+		c, err := FromURI(tv.uri)
+		require.NoError(t, err, "test case at index %d failed conversion", i)
+
+		encoded, err := c.ToCBOR()
+		require.NoError(t, err, "test case at index %d failed encoding", i)
+		assert.Equal(t, tv.cri, encoded, "test case at index %d: got %x", i, encoded)
+	}
+}
+
+func TestFromURI_roundTrip(t *testing.T) {
+	extra := []GoodTestVector{
+		{
+			// a label that contains a dot
+			// echo '[-1, ["a.b", "c"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("82208263612e626163"),
+			uri: "coap://a%2Eb.c",
+		},
+		{
+			// echo '[-1, ["["]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("822081615b"),
+			uri: "coap://%5B",
+		},
+		{
+			// echo '[-1, [""], ["x"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("83208160816178"),
+			uri: "coap:///x",
+		},
+	}
+
+	for i, tv := range append(append([]GoodTestVector{}, GoodTestVectors...), extra...) {
+		decoded, err := Parse(tv.cri)
+		require.NoError(t, err, "test case at index %d failed decoding", i)
+
+		uri, err := decoded.ToURI()
+		require.NoError(t, err, "test case at index %d failed translation to URI", i)
+		assert.Equal(t, tv.uri, uri.String(), "test case at index %d", i)
+
+		c, err := FromURI(uri.String())
+		require.NoError(t, err, "test case at index %d failed conversion", i)
+
+		again, err := c.ToURI()
+		require.NoError(t, err, "test case at index %d failed translation to URI", i)
+		assert.Equal(t, tv.uri, again.String(), "test case at index %d", i)
+	}
+
+	c, err := FromURL(&url.URL{Scheme: "coap", Host: "h", ForceQuery: true})
+	require.NoError(t, err)
+	assert.True(t, c.Query.IsSet())
+	assert.Equal(t, []string{""}, c.Query.GetValues())
+}
+
+func TestCRI_ToURI_ko(t *testing.T) {
+	withScheme := func(c *CRI) *CRI {
+		_ = c.Scheme.SetID(-1)
+		return c
+	}
+
+	tvs := []struct {
+		cri         *CRI
+		expectedErr error
+	}{
+		{
+			// [0, ["a"]], which Parse rejects
+			cri:         &CRI{Discard: Discard{val: uint64(0)}, Path: Path{Items{values: []string{"a"}}}},
+			expectedErr: ErrDiscardZeroWithPath,
+		},
+		{
+			// echo '[true, ["", "a"]]' | diag2cbor.rb | xxd -p
+			cri:         mustParse(t, "82f582606161"),
+			expectedErr: ErrPathNotAbsNoSchemeEmpty,
+		},
+		{
+			// [-1, true, ["", "a"]], which Parse rejects
+			cri:         withScheme(&CRI{Authority: Authority{IsTrue: true}, Path: Path{Items{values: []string{"", "a"}}}}),
+			expectedErr: ErrPathNotAbsRootlessEmpty,
+		},
+		{
+			// echo '[-1, null, ["", "a"]]' | diag2cbor.rb | xxd -p
+			cri:         mustParse(t, "8320f682606161"),
+			expectedErr: ErrPathNotAbsRootlessEmpty,
+		},
+	}
+
+	for i, tv := range tvs {
+		_, err := tv.cri.ToURI()
+		assert.ErrorIs(t, err, tv.expectedErr, "test case at index %d", i)
+	}
+}
+
+func TestCRI_ToURI_emptyHost(t *testing.T) {
+	tvs := []struct {
+		cri string
+		uri string
+	}{
+		{
+			// echo '[-1, [""], ["x"]]' | diag2cbor.rb | xxd -p
+			cri: "83208160816178",
+			uri: "coap:///x",
+		},
+		{
+			// echo '[-1, ["", 5683], ["x"]]' | diag2cbor.rb | xxd -p
+			cri: "83208260191633816178",
+			uri: "coap://:5683/x",
+		},
+	}
+
+	for i, tv := range tvs {
+		u, err := mustParse(t, tv.cri).ToURI()
+		require.NoError(t, err, "test case at index %d", i)
+		assert.Equal(t, tv.uri, u.String(), "test case at index %d", i)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	tvs := []struct {
+		builder *Builder
+		cri     []byte
+	}{
+		{
+			builder: NewCRI().Scheme("coaps").Host("example.com").Port(5684).Path("sensors", "temp").Query("u=C"),
+			// echo '["coaps", ["example", "com", 5684], ["sensors", "temp"], ["u=C"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8465636f61707383676578616d706c6563636f6d191634826773656e736f72736474656d708163753d43"),
+		},
+		{
+			builder: NewCRI().SchemeID(-1).Rootless().Path("a").Fragment("f"),
+			// echo '[-1, true, ["a"], null, "f"]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8520f5816161f66166"),
+		},
+		{
+			builder: NewCRI().Scheme("coap").HostIP(net.ParseIP("fe80::1")).Zone("eth0"),
+			// echo "[\"coap\", [h'fe800000000000000000000000000001', \"eth0\"]]" | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8264636f61708250fe8000000000000000000000000000016465746830"),
+		},
+		{
+			builder: NewCRI().Scheme("coap").Userinfo("u").HostIP(net.ParseIP("192.0.2.1")),
+			// echo "[\"coap\", [false, \"u\", h'c0000201']]" | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8264636f617083f4617544c0000201"),
+		},
+		{
+			builder: NewCRI().SchemeID(-1),
+			// echo '[-1]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8120"),
+		},
+		{
+			builder: NewReference().Discard(2).Path("a"),
+			// echo '[2, ["a"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8202816161"),
+		},
+		{
+			builder: NewReference().DiscardAll().Path("a"),
+			// echo '[true, ["a"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("82f5816161"),
+		},
+		{
+			builder: NewReference().Host("h").Path("p"),
+			// echo '[null, ["h"], ["p"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("83f6816168816170"),
+		},
+		{
+			builder: NewReference(),
+			// echo '[]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("80"),
+		},
+		{
+			builder: NewReference().Path("a"),
+			// echo '[1, ["a"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8201816161"),
+		},
+		{
+			builder: NewReference().Query("q"),
+			// echo '[0, null, ["q"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8300f6816171"),
+		},
+	}
+
+	for i, tv := range tvs {
+		c, err := tv.builder.Build()
+		require.NoError(t, err, "test case at index %d failed building", i)
+
+		encoded, err := c.ToCBOR()
+		require.NoError(t, err, "test case at index %d failed encoding", i)
+		assert.Equal(t, tv.cri, encoded, "test case at index %d: got %x", i, encoded)
+	}
+}
+
+func TestBuilder_ko(t *testing.T) {
+	tvs := []struct {
+		builder     *Builder
+		expectedErr string
+	}{
+		{
+			builder:     NewCRI().Host("example.com"),
+			expectedErr: "Build: missing scheme",
+		},
+		{
+			builder:     NewCRI().Scheme("CoAP"),
+			expectedErr: "Scheme: scheme-name CoAP does not match scheme RE ([a-z][a-z0-9+.-]*)",
+		},
+		{
+			builder:     NewCRI().SchemeID(1),
+			expectedErr: "SchemeID: scheme-id must be nint, got 1",
+		},
+		{
+			builder:     NewCRI().Scheme("coap").Port(5683),
+			expectedErr: "Build: port and userinfo require a host",
+		},
+		{
+			builder:     NewCRI().Scheme("coap").Host("h").Zone("eth0"),
+			expectedErr: "Zone: zone-id requires an IPv6 host-ip",
+		},
+		{
+			builder:     NewCRI().Scheme("urn").Rootless().Host("h"),
+			expectedErr: "Host: rootless CRI cannot have a host",
+		},
+		{
+			builder:     NewCRI().Scheme("coap").Host("").Path("x"),
+			expectedErr: "Host: host-name cannot be empty",
+		},
+		{
+			builder:     NewCRI().Scheme("coap").HostLabels("").Path("x"),
+			expectedErr: "HostLabels: host-name cannot be empty",
+		},
+		{
+			builder:     NewCRI().Scheme("coap").Discard(1),
+			expectedErr: "Discard: not allowed in an absolute CRI",
+		},
+		{
+			builder:     NewReference().Scheme("coap"),
+			expectedErr: "Scheme: not allowed in a relative reference",
+		},
+		{
+			builder:     NewReference().Discard(128),
+			expectedErr: "Discard: discard must be in range 0..127, got 128",
+		},
+		{
+			builder:     NewReference().Discard(1).Host("h"),
+			expectedErr: "Build: discard cannot be combined with a host",
+		},
+	}
+
+	for i, tv := range tvs {
+		_, err := tv.builder.Build()
+		assert.EqualError(t, err, tv.expectedErr, "test case at index %d", i)
+	}
+}
+
+func TestScheme_accessors(t *testing.T) {
+	var s Scheme
+
+	_, ok := s.ID()
+	assert.False(t, ok)
+	_, ok = s.Name()
+	assert.False(t, ok)
+
+	require.NoError(t, s.SetID(-2))
+	id, ok := s.ID()
+	assert.True(t, ok)
+	assert.Equal(t, int64(-2), id)
+	name, ok := s.Name()
+	assert.True(t, ok)
+	assert.Equal(t, "coaps", name)
+
+	require.NoError(t, s.SetID(-1000))
+	_, ok = s.Name()
+	assert.False(t, ok)
+
+	require.NoError(t, s.SetName("x-test"))
+	_, ok = s.ID()
+	assert.False(t, ok)
+	name, ok = s.Name()
+	assert.True(t, ok)
+	assert.Equal(t, "x-test", name)
+
+	assert.EqualError(t, s.SetID(0), "scheme-id must be nint, got 0")
+	assert.Error(t, s.SetName("X-Test"))
+}
+
+func TestSet_decoded(t *testing.T) {
+	var a Authority
+
+	require.NoError(t, a.Set([]interface{}{false, "u", "acme", "example", uint64(5683)}))
+	assert.Equal(t, "u@acme.example:5683", a.String())
+
+	require.NoError(t, a.SetHostPort([]interface{}{[]byte{192, 168, 0, 1}}))
+	assert.Equal(t, "192.168.0.1", a.String())
+
+	// a single text is a dotted host-name, as with Host.Set
+	require.NoError(t, a.Set([]interface{}{"example.com", uint64(5683)}))
+	assert.Equal(t, "example.com", a.Host.Get())
+	u, err := (&CRI{Scheme: Scheme{val: int64(-1)}, Authority: a}).ToURI()
+	require.NoError(t, err)
+	assert.Equal(t, "coap://example.com:5683", u.String())
+
+	assert.EqualError(t, a.Set(false), "unexpected authority type: false")
+	assert.EqualError(t, a.SetHostPort([]interface{}{"h", uint64(1), uint64(2)}), "wrong number of elements in authority: 3")
+
+	var p Path
+
+	require.NoError(t, p.Set([]interface{}{"a", []interface{}{"caf", []byte{0xe9}}}))
+	require.NoError(t, p.SetValues([]interface{}{"b"}))
+	assert.Equal(t, []string{"a", "caf\xe9", "b"}, p.GetSegments())
+
+	require.NoError(t, p.Set(nil))
+	assert.EqualError(t, p.Set(uint64(1)), "unknown type: uint64")
+	assert.EqualError(t, p.SetValues([]interface{}{uint64(1)}), "unknow type for item: uint64")
+	assert.EqualError(t, p.SetValues([]interface{}{[]interface{}{}}), "percent-encoded text cannot be empty")
+
+	// a valid UTF-8 byte string element stays percent-encoded
+	var q Query
+
+	require.NoError(t, q.SetValues([]interface{}{[]interface{}{"k", []byte("=&")}}))
+	assert.Equal(t, "k%3D%26", q.String())
+
+	var f Fragment
+
+	require.NoError(t, f.Set("f"))
+	assert.Equal(t, "f", f.Get())
+	assert.EqualError(t, f.Set(nil), "unknown type for fragment: <nil>")
+
+	// not valid UTF-8, which is encoded as a PET
+	require.NoError(t, f.Set("caf\xe9"))
+	assert.Equal(t, "caf%E9", f.String())
+	got, err := f.toCBOR(LatestVersion)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"caf", []byte{0xe9}}, got)
+}
+
+func TestHost_accessors(t *testing.T) {
+	var h Host
+
+	_, ok := h.IP()
+	assert.False(t, ok)
+	_, ok = h.Name()
+	assert.False(t, ok)
+
+	h.SetName("example.com")
+	name, ok := h.Name()
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", name)
+	_, ok = h.IP()
+	assert.False(t, ok)
+
+	require.NoError(t, h.SetLabels([]string{"example", "com"}))
+	name, ok = h.Name()
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", name)
+
+	require.NoError(t, h.SetIP(net.ParseIP("2001:db8::1")))
+	ip, ok := h.IP()
+	assert.True(t, ok)
+	assert.Equal(t, net.ParseIP("2001:db8::1"), ip)
+	_, ok = h.Name()
+	assert.False(t, ok)
+
+	assert.EqualError(t, h.SetIP(net.IP{1, 2, 3}), "host-ip must be 4 or 16 bytes, got 3")
+}
+
+func TestDiscard_accessors(t *testing.T) {
+	var d Discard
+
+	assert.False(t, d.All())
+	assert.False(t, d.IsTrue())
+	_, ok := d.Count()
+	assert.False(t, ok)
+	_, err := d.ComputePathPrefix(false)
+	assert.EqualError(t, err, "discard is not set")
+
+	require.NoError(t, d.SetCount(3))
+	n, ok := d.Count()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), n)
+	assert.False(t, d.All())
+	prefix, err := d.ComputePathPrefix(true)
+	require.NoError(t, err)
+	assert.Equal(t, "../../", prefix)
+
+	d.SetAll()
+	assert.True(t, d.All())
+	assert.True(t, d.IsTrue())
+	_, ok = d.Count()
+	assert.False(t, ok)
+	prefix, err = d.ComputePathPrefix(true)
+	require.NoError(t, err)
+	assert.Equal(t, "/", prefix)
+
+	assert.EqualError(t, d.SetCount(128), "discard must be in range 0..127, got 128")
+	assert.EqualError(t, d.Set(false), "discard cannot be false")
+}
+
+func TestCRI_Validate(t *testing.T) {
+	withScheme := func(c *CRI) *CRI {
+		_ = c.Scheme.SetID(-1)
+		return c
+	}
+
+	var port Port
+	_ = port.Set(uint64(5683))
+
+	var ip4 Host
+	_ = ip4.SetIP(net.IP{192, 0, 2, 1})
+
+	badUserinfo := "\xff"
+
+	tvs := []struct {
+		cri         *CRI
+		expectedErr string
+	}{
+		{
+			// [0, ["a"]], which Parse rejects
+			cri:         &CRI{Discard: Discard{val: uint64(0)}, Path: Path{Items{values: []string{"a"}}}},
+			expectedErr: ErrDiscardZeroWithPath.Error(),
+		},
+		{
+			// [-1, true, ["", "a"]], which Parse rejects
+			cri:         withScheme(&CRI{Authority: Authority{IsTrue: true}, Path: Path{Items{values: []string{"", "a"}}}}),
+			expectedErr: ErrPathNotAbsRootlessEmpty.Error() + ": true authority followed by an empty segment",
+		},
+		{
+			cri:         &CRI{},
+			expectedErr: "neither an absolute CRI nor a relative reference",
+		},
+		{
+			cri:         withScheme(&CRI{Discard: Discard{val: uint64(1)}}),
+			expectedErr: "discard cannot be combined with scheme or authority",
+		},
+		{
+			cri:         &CRI{Discard: Discard{val: uint64(1)}, Authority: Authority{IsTrue: true}},
+			expectedErr: "discard cannot be combined with scheme or authority",
+		},
+		{
+			cri:         &CRI{Discard: Discard{val: false}},
+			expectedErr: "discard cannot be false",
+		},
+		{
+			cri:         &CRI{Scheme: Scheme{val: "CoAP"}, Authority: Authority{IsNull: true}},
+			expectedErr: "scheme-name CoAP does not match scheme RE ([a-z][a-z0-9+.-]*)",
+		},
+		{
+			cri:         withScheme(&CRI{Authority: Authority{IsNull: true, IsTrue: true}}),
+			expectedErr: "authority cannot be both null and true",
+		},
+		{
+			cri:         withScheme(&CRI{Authority: Authority{IsNull: true, Port: port}}),
+			expectedErr: "a null or true authority cannot have host, port or userinfo",
+		},
+		{
+			cri:         withScheme(&CRI{Authority: Authority{Port: port}}),
+			expectedErr: "port and userinfo require a host",
+		},
+		{
+			cri:         withScheme(&CRI{Authority: Authority{Host: Host{val: net.IP{192, 0, 2, 1}, zone: "eth0"}}}),
+			expectedErr: "zone-id requires an IPv6 host-ip",
+		},
+		{
+			// would be encoded as [-1, [null], ["a"]], and read as "coap:/a"
+			cri:         withScheme(&CRI{Path: Path{Items{values: []string{"a"}}}}),
+			expectedErr: "an absolute CRI needs an authority that is null, true or has a host",
+		},
+		{
+			cri:         withScheme(&CRI{Authority: Authority{Host: Host{val: "h\xff"}}}),
+			expectedErr: `invalid UTF-8 in host-name "h\xff"`,
+		},
+		{
+			cri:         withScheme(&CRI{Authority: Authority{Host: Host{val: []string{"a", "\xff"}}}}),
+			expectedErr: `invalid UTF-8 in host-name label "\xff"`,
+		},
+		{
+			cri:         withScheme(&CRI{Authority: Authority{Host: Host{val: net.IP(net.IPv6loopback), zone: "\xff"}}}),
+			expectedErr: `invalid UTF-8 in zone-id "\xff"`,
+		},
+		{
+			cri:         withScheme(&CRI{Authority: Authority{Host: ip4, Userinfo: Userinfo{val: &badUserinfo}}}),
+			expectedErr: `invalid UTF-8 in userinfo "\xff"`,
+		},
+	}
+
+	for i, tv := range tvs {
+		assert.EqualError(t, tv.cri.Validate(), tv.expectedErr, "test case at index %d", i)
+
+		_, err := tv.cri.ToCBOR()
+		assert.EqualError(t, err, tv.expectedErr, "test case at index %d (ToCBOR)", i)
+
+		_, err = tv.cri.ToURI()
+		assert.EqualError(t, err, tv.expectedErr, "test case at index %d (ToURI)", i)
+	}
+
+	ok := withScheme(&CRI{Authority: Authority{Host: ip4, Port: port}})
+	assert.NoError(t, ok.Validate())
+	assert.True(t, ok.IsAbs())
+
+	ok.Discard = Discard{val: uint64(0)}
+	assert.False(t, ok.IsAbs())
+}
+
+// withDefaultSchemes resets the scheme registry to its default entries for the
+// duration of the test, so that the schemes registered by the test do not leak
+// into other tests (or into other runs of the same test)
+func withDefaultSchemes(t *testing.T) {
+	saved := schemes
+	schemes = newSchemeRegistry(defaultSchemes)
+	t.Cleanup(func() { schemes = saved })
+}
+
+func TestRegisterScheme(t *testing.T) {
+	withDefaultSchemes(t)
+
+	id, ok := LookupSchemeID("coaps")
+	assert.True(t, ok)
+	assert.Equal(t, int64(-2), id)
+
+	name, ok := LookupSchemeName(-6)
+	assert.True(t, ok)
+	assert.Equal(t, "did", name)
+
+	name, ok = LookupSchemeName(-26)
+	assert.True(t, ok)
+	assert.Equal(t, "coaps+ws", name)
+
+	_, ok = LookupSchemeID("x-reg")
+	assert.False(t, ok)
+
+	require.NoError(t, RegisterScheme(-101, "x-reg"))
+	// registering the same mapping again is fine
+	require.NoError(t, RegisterScheme(-101, "x-reg"))
+
+	id, ok = LookupSchemeID("x-reg")
+	assert.True(t, ok)
+	assert.Equal(t, int64(-101), id)
+	assert.Equal(t, "x-reg", SchemeIDtoString(-101))
+
+	assert.EqualError(t, RegisterScheme(-1, "x-other"), "scheme-id -1 already registered for coap")
+	assert.EqualError(t, RegisterScheme(-102, "coap"), "scheme-name coap already registered with scheme-id -1")
+	assert.EqualError(t, RegisterScheme(1, "x-other"), "scheme-id must be nint, got 1")
+	assert.EqualError(t, RegisterScheme(-102, "X"), "scheme-name X does not match scheme RE ([a-z][a-z0-9+.-]*)")
+
+	c, err := FromURI("x-reg://h")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-101), c.Scheme.Get())
+}
+
+func TestRegisterScheme_concurrent(t *testing.T) {
+	withDefaultSchemes(t)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := int64(-200 - i)
+			name := fmt.Sprintf("x-concurrent-%d", i)
+			assert.NoError(t, RegisterScheme(id, name))
+			got, ok := LookupSchemeID(name)
+			assert.True(t, ok)
+			assert.Equal(t, id, got)
+			_, _ = LookupSchemeName(-1)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+func TestCRI_ToCBOR_WithSchemeID(t *testing.T) {
+	withDefaultSchemes(t)
+
+	require.NoError(t, RegisterScheme(-101, "x-reg"))
+
+	tvs := []struct {
+		cri      string
+		expected []byte
+	}{
+		{
+			// echo '["coap", ["h"]]' | diag2cbor.rb | xxd -p
+			cri: "8264636f6170816168",
+			// echo '[-1, ["h"]]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("8220816168"),
+		},
+		{
+			// echo '["x-reg", ["h"]]' | diag2cbor.rb | xxd -p
+			cri: "8265782d726567816168",
+			// echo '[-101, ["h"]]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("823864816168"),
+		},
+		{
+			// echo '["x-unreg", ["h"]]' | diag2cbor.rb | xxd -p
+			cri: "8267782d756e726567816168",
+			// echo '["x-unreg", ["h"]]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("8267782d756e726567816168"),
+		},
+	}
+
+	for i, tv := range tvs {
+		c := mustParse(t, tv.cri)
+
+		got, err := c.ToCBOR(WithSchemeID())
+		require.NoError(t, err, "test case at index %d", i)
+		assert.Equal(t, tv.expected, got, "test case at index %d: got %x", i, got)
+
+		// the default is to keep the scheme as is
+		got, err = c.ToCBOR()
+		require.NoError(t, err, "test case at index %d", i)
+		assert.Equal(t, MustHexDecode(tv.cri), got, "test case at index %d: got %x", i, got)
+	}
+}
+
+func TestCRI_Compact(t *testing.T) {
+	tvs := []struct {
+		cri      string
+		expected []byte
+		saved    int
+	}{
+		{
+			// echo '["coap", ["192", "168", "0", "1", 5683], ["a"]]' | diag2cbor.rb | xxd -p
+			cri: "8364636f617085633139326331363861306131191633816161",
+			// echo "[-1, [h'c0a80001'], [\"a\"]]" | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("83208144c0a80001816161"),
+			saved:    14,
+		},
+		{
+			// echo '["https", ["Example", "COM", 443]]' | diag2cbor.rb | xxd -p
+			cri: "8265687474707383674578616d706c6563434f4d1901bb",
+			// echo '[-4, ["example", "com"]]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("822382676578616d706c6563636f6d"),
+			saved:    8,
+		},
+		{
+			// echo "[-1, [h'00000000000000000000ffffc0000201', 5684]]" | diag2cbor.rb | xxd -p
+			cri: "8220825000000000000000000000ffffc0000201191634",
+			// echo "[-1, [h'c0000201', 5684]]" | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("82208244c0000201191634"),
+			saved:    12,
+		},
+		{
+			// echo '["coap", ["1.2", "3.4"]]' | diag2cbor.rb | xxd -p
+			cri: "8264636f61708263312e3263332e34",
+			// echo '[-1, ["1.2", "3.4"]]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("82208263312e3263332e34"),
+			saved:    4,
+		},
+		{
+			// echo '[-1, ["2001:db8::1"]]' | diag2cbor.rb | xxd -p
+			cri: "8220816b323030313a6462383a3a31",
+			// echo '[-1, ["2001:db8::1"]]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("8220816b323030313a6462383a3a31"),
+			saved:    0,
+		},
+		{
+			// echo '["x-unreg", ["h"]]' | diag2cbor.rb | xxd -p
+			cri: "8267782d756e726567816168",
+			// echo '["x-unreg", ["h"]]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("8267782d756e726567816168"),
+			saved:    0,
+		},
+		{
+			// echo '[1]' | diag2cbor.rb | xxd -p
+			cri: "8101",
+			// echo '[1]' | diag2cbor.rb | xxd -p
+			expected: MustHexDecode("8101"),
+			saved:    0,
+		},
+	}
+
+	for i, tv := range tvs {
+		c := mustParse(t, tv.cri)
+
+		// WithCompact leaves the CRI untouched
+		got, err := c.ToCBOR(WithCompact())
+		require.NoError(t, err, "test case at index %d", i)
+		assert.Equal(t, tv.expected, got, "test case at index %d: got %x", i, got)
+
+		got, err = c.ToCBOR()
+		require.NoError(t, err, "test case at index %d", i)
+		assert.Equal(t, MustHexDecode(tv.cri), got, "test case at index %d: got %x", i, got)
+
+		saved, err := c.Compact()
+		require.NoError(t, err, "test case at index %d", i)
+		assert.Equal(t, tv.saved, saved, "test case at index %d", i)
+
+		got, err = c.ToCBOR()
+		require.NoError(t, err, "test case at index %d", i)
+		assert.Equal(t, tv.expected, got, "test case at index %d: got %x", i, got)
+	}
+
+	_, err := (&CRI{}).Compact()
+	assert.EqualError(t, err, "neither an absolute CRI nor a relative reference")
+}
+
+func TestCRI_MarshalCBOR(t *testing.T) {
+	type linkSet struct {
+		Anchor  CRI            `cbor:"1,keyasint"`
+		Targets []CRI          `cbor:"2,keyasint"`
+		ByRel   map[string]CRI `cbor:"3,keyasint"`
+		Alt     *CRI           `cbor:"4,keyasint,omitempty"`
+	}
+
+	var ls linkSet
+
+	require.NoError(t, ls.Anchor.UnmarshalCBOR(MustHexDecode("8320816168816161")))
+	ls.Targets = []CRI{
+		*mustParse(t, "8201816162"),
+		*mustParse(t, "81f5"),
+	}
+	ls.ByRel = map[string]CRI{
+		"up": *mustParse(t, "8102"),
+	}
+
+	// echo '{1: [-1, ["h"], ["a"]], 2: [[1, ["b"]], [true]], 3: {"up": [2]}}' | diag2cbor.rb | xxd -p
+	expected := MustHexDecode("a30183208161688161610282820181616281f503a16275708102")
+
+	got, err := cbor.Marshal(ls)
+	require.NoError(t, err)
+	assert.Equal(t, expected, got, "got %x", got)
+
+	var decoded linkSet
+	require.NoError(t, cbor.Unmarshal(got, &decoded))
+	assert.True(t, Equal(&ls.Anchor, &decoded.Anchor))
+	require.Len(t, decoded.Targets, 2)
+	assert.True(t, Equal(&ls.Targets[0], &decoded.Targets[0]))
+	assert.True(t, Equal(&ls.Targets[1], &decoded.Targets[1]))
+	up := decoded.ByRel["up"]
+	assert.True(t, Equal(mustParse(t, "8102"), &up))
+	assert.Nil(t, decoded.Alt)
+
+	// echo '{1: [-1, ["h"], ["a"]], 2: [], 3: {}, 4: ["x", true, ["y"]]}' | diag2cbor.rb | xxd -p
+	withAlt := MustHexDecode("a4018320816168816161028003a004836178f5816179")
+
+	decoded = linkSet{}
+	require.NoError(t, cbor.Unmarshal(withAlt, &decoded))
+	require.NotNil(t, decoded.Alt)
+	u, err := decoded.Alt.ToURI()
+	require.NoError(t, err)
+	assert.Equal(t, "x:y", u.String())
+
+	// echo '{1: ["SCHEME"]}' | diag2cbor.rb | xxd -p
+	err = cbor.Unmarshal(MustHexDecode("a1018166534348454d45"), &decoded)
+	assert.ErrorIs(t, err, ErrBadScheme)
+
+	_, err = cbor.Marshal(linkSet{})
+	assert.EqualError(t, err, "neither an absolute CRI nor a relative reference")
+
+	// an optional CRI must be a *CRI: omitempty does not omit a zero CRI
+	type optional struct {
+		Must CRI  `cbor:"1,keyasint,omitempty"`
+		May  *CRI `cbor:"2,keyasint,omitempty"`
+	}
+
+	_, err = cbor.Marshal(optional{})
+	assert.EqualError(t, err, "neither an absolute CRI nor a relative reference")
+
+	// echo '{1: [2]}' | diag2cbor.rb | xxd -p
+	got, err = cbor.Marshal(optional{Must: *mustParse(t, "8102")})
+	require.NoError(t, err)
+	assert.Equal(t, MustHexDecode("a1018102"), got, "got %x", got)
+
+	// echo '{1: [2], 2: [true]}' | diag2cbor.rb | xxd -p
+	got, err = cbor.Marshal(optional{Must: *mustParse(t, "8102"), May: mustParse(t, "81f5")})
+	require.NoError(t, err)
+	assert.Equal(t, MustHexDecode("a20181020281f5"), got, "got %x", got)
+}
+
+func TestCRI_tags(t *testing.T) {
+	// echo '[-1, ["h"], ["a"]]' | diag2cbor.rb | xxd -p
+	untagged := MustHexDecode("8320816168816161")
+	// echo '99([-1, ["h"], ["a"]])' | diag2cbor.rb | xxd -p
+	tagged := MustHexDecode("d8638320816168816161")
+	// echo '32("coap://h/a")' | diag2cbor.rb | xxd -p
+	uriTagged := MustHexDecode("d8206a636f61703a2f2f682f61")
+
+	c, err := Parse(tagged)
+	require.NoError(t, err)
+
+	got, err := c.ToCBOR()
+	require.NoError(t, err)
+	assert.Equal(t, untagged, got, "got %x", got)
+
+	got, err = c.ToCBOR(WithTag())
+	require.NoError(t, err)
+	assert.Equal(t, tagged, got, "got %x", got)
+
+	got, err = c.ToURITag()
+	require.NoError(t, err)
+	assert.Equal(t, uriTagged, got, "got %x", got)
+
+	fromURI, err := ParseURITag(uriTagged)
+	require.NoError(t, err)
+	assert.True(t, Equal(c, fromURI))
+
+	// echo '99([])' | diag2cbor.rb | xxd -p
+	c, err = Parse(MustHexDecode("d86380"))
+	require.NoError(t, err)
+	got, err = c.ToCBOR(WithTag())
+	require.NoError(t, err)
+	assert.Equal(t, MustHexDecode("d86380"), got, "got %x", got)
+
+	// echo '24([-1])' | diag2cbor.rb | xxd -p
+	_, err = Parse(MustHexDecode("d8188120"))
+	assert.EqualError(t, err, "cri: unexpected tag 24")
+	assert.ErrorIs(t, err, ErrInvalidCBOR)
+
+	_, err = ParseURITag(tagged)
+	assert.EqualError(t, err, "expecting tag 32")
+
+	// echo '32(1)' | diag2cbor.rb | xxd -p
+	_, err = ParseURITag(MustHexDecode("d82001"))
+	assert.EqualError(t, err, "tag 32 content: cbor: cannot unmarshal positive integer into Go value of type string")
+
+	// mixed-format documents decode in one pass
+	var links []CRI
+
+	// echo '[[-1, ["h"], ["a"]], 99([1, ["b"]]), 32("coap://h/a")]' | diag2cbor.rb | xxd -p
+	require.NoError(t, cbor.Unmarshal(MustHexDecode("838320816168816161d8638201816162d8206a636f61703a2f2f682f61"), &links))
+	require.Len(t, links, 3)
+	assert.True(t, Equal(&links[0], &links[2]))
+	assert.True(t, Equal(mustParse(t, "8201816162"), &links[1]))
+}
+
+func TestCRI_Diag_full_circle(t *testing.T) {
+	for i, tv := range GoodTestVectors {
+		c, err := Parse(tv.cri)
+		require.NoError(t, err, "test case at index %d", i)
+
+		d, err := ParseDiag(c.Diag())
+		require.NoError(t, err, "test case at index %d: %s", i, c.Diag())
+		assert.True(t, Equal(c, d), "test case at index %d: %s", i, c.Diag())
+	}
+}
+
+func TestCRI_Diag(t *testing.T) {
+	tvs := []struct {
+		cri  string
+		diag string
+	}{
+		{
+			// echo '[]' | diag2cbor.rb | xxd -p
+			cri:  "80",
+			diag: `[]`,
+		},
+		{
+			// echo '["coap+tcp", ["acme", "example", 5683], ["a", "b", "c"]]' | diag2cbor.rb | xxd -p
+			cri:  "8368636f61702b746370836461636d65676578616d706c6519163383616161626163",
+			diag: `["coap+tcp", ["acme", "example", 5683], ["a", "b", "c"]]`,
+		},
+		{
+			// echo "[-1, [h'fe800000000000000000000000000001', \"eth0\"]]" | diag2cbor.rb | xxd -p
+			cri:  "82208250fe8000000000000000000000000000016465746830",
+			diag: `[-1, [h'fe800000000000000000000000000001', "eth0"]]`,
+		},
+		{
+			// echo '[true, ["a\"b", "c\\d"], null, "\u0001"]' | diag2cbor.rb | xxd -p
+			cri:  "84f5826361226263635c64f66101",
+			diag: `[true, ["a\"b", "c\\d"], null, "\u0001"]`,
+		},
+	}
+
+	for i, tv := range tvs {
+		c := mustParse(t, tv.cri)
+		assert.Equal(t, tv.diag, c.Diag(), "test case at index %d", i)
+
+		d, err := ParseDiag(tv.diag)
+		require.NoError(t, err, "test case at index %d", i)
+		got, err := d.ToCBOR()
+		require.NoError(t, err, "test case at index %d", i)
+		assert.Equal(t, MustHexDecode(tv.cri), got, "test case at index %d: got %x", i, got)
+	}
+
+	assert.Equal(t, "# neither an absolute CRI nor a relative reference", (&CRI{}).Diag())
+}
+
+func TestParseDiag(t *testing.T) {
+	tvs := []struct {
+		diag string
+		cri  []byte
+	}{
+		{
+			diag: `cri'coap://acme.example/a?q'`,
+			// echo '[-1, ["acme", "example"], ["a"], ["q"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8420826461636d65676578616d706c65816161816171"),
+		},
+		{
+			diag: `99(cri'../a')`,
+			// echo '[2, ["a"]]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("8202816161"),
+		},
+		{
+			diag: "[ -1, / scheme-id / [ h'c0 a8 00 01' ], # host-ip\n [\"\\ud83d\\ude00\", \"b'c\"] ]",
+			// echo "[-1, [h'c0a80001'], [\"😀\", \"b'c\"]]" | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("83208144c0a800018264f09f988063622763"),
+		},
+		{
+			// [0] is output as []
+			diag: `[-0]`,
+			// echo '[]' | diag2cbor.rb | xxd -p
+			cri: MustHexDecode("80"),
+		},
+	}
+
+	for i, tv := range tvs {
+		c, err := ParseDiag(tv.diag)
+		require.NoError(t, err, "test case at index %d", i)
+
+		got, err := c.ToCBOR()
+		require.NoError(t, err, "test case at index %d", i)
+		assert.Equal(t, tv.cri, got, "test case at index %d: got %x", i, got)
+	}
+
+	// the cri'...' literal is encoded with the options of ParseDiag
+	c, err := ParseDiag(`cri'coap://a.example/x'`, WithVersion(Href09))
+	require.NoError(t, err)
+
+	got, err := c.ToCBOR(WithVersion(Href09))
+	require.NoError(t, err)
+	// echo '[-1, ["a.example"], ["x"]]' | diag2cbor.rb | xxd -p
+	assert.Equal(t, MustHexDecode("83208169612e6578616d706c65816178"), got)
+
+	kos := []struct {
+		diag        string
+		expectedErr string
+	}{
+		{`[-1`, "EDN at offset 3: unexpected end of input"},
+		{`[-1 2]`, "EDN at offset 4: expecting ',' or ']'"},
+		{`[-1] x`, "EDN at offset 5: unexpected trailing characters"},
+		{`["a]`, "EDN at offset 4: unterminated string"},
+		{`[h'0g']`, "EDN at offset 6: bad hex byte string: encoding/hex: invalid byte: U+0067 'g'"},
+		{`{}`, "EDN at offset 0: unexpected character '{'"},
+		{`cri'%zz'`, `EDN at offset 8: cri literal: invalid URL escape "%zz"`},
+		{`["SCHEME"]`, "scheme (index 0): scheme-name SCHEME does not match scheme RE ([a-z][a-z0-9+.-]*)"},
+	}
+
+	for i, tv := range kos {
+		_, err := ParseDiag(tv.diag)
+		assert.EqualError(t, err, tv.expectedErr, "test case at index %d", i)
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	tvs := []struct {
+		cri         string
+		reencodes   bool
+		expectedErr string
+	}{
+		{
+			// echo '[-1, ["h"]]' | diag2cbor.rb | xxd -p
+			cri:       "8220816168",
+			reencodes: true,
+		},
+		{
+			// echo '99([-1, ["h"]])' | diag2cbor.rb | xxd -p
+			cri:       "d8638220816168",
+			reencodes: true,
+		},
+		{
+			// echo '[0]' | diag2cbor.rb | xxd -p
+			cri:       "8100",
+			reencodes: false,
+		},
+		{
+			// echo '[-1, ["h"], null]' | diag2cbor.rb | xxd -p
+			cri:       "8320816168f6",
+			reencodes: false,
+		},
+		{
+			// [-1_0, ["h"]]
+			cri:         "823800816168",
+			expectedErr: "cri: not in core deterministic encoding: at offset 1: integer argument not in its shortest form",
+		},
+		{
+			// [_ -1, ["h"]]
+			cri:         "9f20816168ff",
+			expectedErr: "cri: not in core deterministic encoding: at offset 0: indefinite length",
+		},
+		{
+			// [-1, [(_ "h")]]
+			cri:         "8220817f6168ff",
+			expectedErr: "cri: not in core deterministic encoding: at offset 3: indefinite length",
+		},
+		{
+			// [-1, ["h"]] 0
+			cri:         "822081616800",
+			expectedErr: "cri: not in core deterministic encoding: 1 trailing bytes after the data item",
+		},
+		{
+			// [-1, ["h"
+			cri:         "82208161",
+			expectedErr: "cri: not in core deterministic encoding: at offset 3: truncated CBOR data item",
+		},
+	}
+
+	for i, tv := range tvs {
+		_, reencodes, err := ParseStrict(MustHexDecode(tv.cri))
+		if tv.expectedErr != "" {
+			assert.EqualError(t, err, tv.expectedErr, "test case at index %d", i)
+			assert.ErrorIs(t, err, ErrNotDeterministic, "test case at index %d", i)
+
+			// accepted (or rejected for other reasons) when not strict
+			_, err = Parse(MustHexDecode(tv.cri))
+			assert.False(t, errors.Is(err, ErrNotDeterministic), "test case at index %d", i)
+			continue
+		}
+		require.NoError(t, err, "test case at index %d", i)
+		assert.Equal(t, tv.reencodes, reencodes, "test case at index %d", i)
+	}
+}
+
+func TestCheckDeterministic(t *testing.T) {
+	tvs := []struct {
+		item        string
+		expectedErr string
+	}{
+		// 1.0 (half)
+		{item: "f93c00"},
+		// 1.0 (single)
+		{item: "fa3f800000", expectedErr: "at offset 0: float not in its shortest form"},
+		// 100000.0 (single)
+		{item: "fa47c35000"},
+		// 5.960464477539063e-8 (single, smallest half subnormal)
+		{item: "fa33800000", expectedErr: "at offset 0: float not in its shortest form"},
+		// 1.0 (double)
+		{item: "fb3ff0000000000000", expectedErr: "at offset 0: float not in its shortest form"},
+		// 1.1 (double)
+		{item: "fb3ff199999999999a"},
+		// {1: 0, 2: 0}
+		{item: "a201000200"},
+		// {2: 0, 1: 0}
+		{item: "a202000100", expectedErr: "at offset 3: map keys not sorted or duplicated"},
+		// {1: 0, 1: 0}
+		{item: "a201000100", expectedErr: "at offset 3: map keys not sorted or duplicated"},
+		// simple(16) in two bytes
+		{item: "f810", expectedErr: "at offset 0: integer argument not in its shortest form"},
+		// 24 in two bytes
+		{item: "1818"},
+		// 255 in three bytes
+		{item: "1900ff", expectedErr: "at offset 0: integer argument not in its shortest form"},
+		// reserved additional information
+		{item: "1c", expectedErr: "at offset 0: malformed CBOR: reserved additional information 28"},
+	}
+
+	for i, tv := range tvs {
+		err := checkDeterministic(MustHexDecode(tv.item))
+		if tv.expectedErr == "" {
+			assert.NoError(t, err, "test case at index %d", i)
+		} else {
+			assert.EqualError(t, err, tv.expectedErr, "test case at index %d", i)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	// echo '[-1, ["h"], ["a"]] [] 99([1, ["b"]]) ["SCHEME"] [true]' | diag2cbor.rb | xxd -p
+	seq := MustHexDecode("832081616881616180d86382018161628166534348454d4581f5")
+
+	d := NewDecoder(bytes.NewReader(seq))
+
+	var decoded []*CRI
+
+	for _, offset := range []int64{0, 8, 9} {
+		c, err := d.Decode()
+		require.NoError(t, err, "item at offset %d", offset)
+		assert.Equal(t, offset, d.Offset())
+		decoded = append(decoded, c)
+	}
+
+	// a bad CRI does not stop decoding
+	_, err := d.Decode()
+	assert.EqualError(t, err, "item at offset 16: scheme (index 0): scheme-name SCHEME does not match scheme RE ([a-z][a-z0-9+.-]*)")
+	assert.ErrorIs(t, err, ErrBadScheme)
+	var de *DecodeError
+	require.ErrorAs(t, err, &de)
+	assert.Equal(t, int64(16), de.Offset)
+
+	c, err := d.Decode()
+	require.NoError(t, err)
+	assert.Equal(t, int64(24), d.Offset())
+	decoded = append(decoded, c)
+
+	_, err = d.Decode()
+	assert.Equal(t, io.EOF, err)
+
+	// encode what was decoded
+	var buf bytes.Buffer
+
+	e := NewEncoder(&buf)
+	for _, c := range decoded {
+		require.NoError(t, e.Encode(c))
+	}
+
+	// echo '[-1, ["h"], ["a"]] [] [1, ["b"]] [true]' | diag2cbor.rb | xxd -p
+	assert.Equal(t, MustHexDecode("832081616881616180820181616281f5"), buf.Bytes())
+
+	assert.EqualError(t, e.Encode(&CRI{}), "neither an absolute CRI nor a relative reference")
+}
+
+func TestDecoder_ko(t *testing.T) {
+	tvs := []struct {
+		seq         string
+		expectedErr string
+	}{
+		{
+			// [-1, ["h"]] [-1,
+			seq:         "82208161688220",
+			expectedErr: "item at offset 5: unexpected EOF",
+		},
+		{
+			// [-1, ["h"]] [_ -1, ["h"]
+			seq:         "82208161689f20816168",
+			expectedErr: "item at offset 5: unexpected EOF",
+		},
+		{
+			// [-1, ["h"]] "h
+			seq:         "822081616862",
+			expectedErr: "item at offset 5: unexpected EOF",
+		},
+		{
+			// [-1, ["h"]] break
+			seq:         "8220816168ff",
+			expectedErr: "item at offset 5: malformed CBOR: unexpected break",
+		},
+		{
+			// [-1, ["h"]] (_ 1)
+			seq:         "82208161687f01",
+			expectedErr: "item at offset 5: malformed CBOR: bad chunk in indefinite-length string",
+		},
+		{
+			// [-1, ["h"]] [[[[[[[[[[[[[[[[[[
+			seq:         "8220816168818181818181818181818181818181818181",
+			expectedErr: "item at offset 5: data item nested too deeply",
+		},
+	}
+
+	for i, tv := range tvs {
+		d := NewDecoder(bytes.NewReader(MustHexDecode(tv.seq)))
+
+		_, err := d.Decode()
+		require.NoError(t, err, "test case at index %d", i)
+
+		_, err = d.Decode()
+		assert.EqualError(t, err, tv.expectedErr, "test case at index %d", i)
+
+		// the error is sticky
+		_, err = d.Decode()
+		assert.EqualError(t, err, tv.expectedErr, "test case at index %d", i)
+	}
+}
+
+func TestDecoder_strict(t *testing.T) {
+	// echo '[-1, ["h"]]' | diag2cbor.rb | xxd -p
+	// [_ -1, ["h"]]
+	d := NewDecoder(bytes.NewReader(MustHexDecode("82208161689f20816168ff")), WithStrict())
+
+	_, err := d.Decode()
+	require.NoError(t, err)
+
+	_, err = d.Decode()
+	assert.ErrorIs(t, err, ErrNotDeterministic)
+
+	_, err = d.Decode()
+	assert.Equal(t, io.EOF, err)
+}
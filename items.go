@@ -2,12 +2,14 @@ package href
 
 import (
 	"fmt"
-	"math"
 	"strings"
 )
 
 type Items struct {
 	values []string
+	// pets holds, at the index of each value that was read as a PET, its
+	// elements; it is nil if there is none
+	pets []pet
 }
 
 func (o Items) Count() uint64 {
@@ -21,6 +23,28 @@ func (o Items) String(sep string) string {
 	return strings.Join(o.values, sep)
 }
 
+// escapedValues returns the values percent-encoded according to allowed, and
+// the byte string elements of those read as a PET percent-encoded in full
+func (o Items) escapedValues(allowed func(byte) bool) []string {
+	escaped := make([]string, 0, len(o.values))
+	for i, v := range o.values {
+		if p := o.pet(i); p != nil {
+			escaped = append(escaped, p.pctEncode(allowed))
+			continue
+		}
+		escaped = append(escaped, pctEncode(v, allowed))
+	}
+	return escaped
+}
+
+// pet returns the elements of the i-th value if it was read as a PET, or nil
+func (o Items) pet(i int) pet {
+	if i >= len(o.pets) {
+		return nil
+	}
+	return o.pets[i]
+}
+
 func (o Items) IsSet() bool {
 	return len(o.values) > 0
 }
@@ -32,24 +56,36 @@ func (o Items) Get() interface{} {
 	return o.values
 }
 
+// GetValues returns the values, or nil if there are none
 func (o Items) GetValues() []string {
 	if !o.IsSet() {
-		panic("there are no values to get")
+		return nil
 	}
 	return o.values
 }
 
+func (o Items) clone() Items {
+	if o.values == nil {
+		return Items{}
+	}
+	c := Items{values: append([]string{}, o.values...)}
+	if o.pets != nil {
+		c.pets = append([]pet{}, o.pets...)
+	}
+	return c
+}
+
 func (o *Items) Reset() {
 	o.values = []string{}
+	o.pets = nil
 }
 
+// Set appends the items from their CBOR decoded form: nil (no items) or the
+// array taken by SetValues
 func (o *Items) Set(v interface{}) error {
 	switch t := v.(type) {
 	case []interface{}:
-		if err := o.SetValues(t); err != nil {
-			return err
-		}
-		return nil
+		return o.SetValues(t)
 	case nil:
 		return nil
 	default:
@@ -57,25 +93,91 @@ func (o *Items) Set(v interface{}) error {
 	}
 }
 
+// SetValues appends the items, each a string or the elements of a
+// percent-encoded text (PET), strings and []byte.  A string that is not valid
+// UTF-8 is encoded as a PET by ToCBOR.
 func (o *Items) SetValues(v []interface{}) error {
+	values := Items{values: make([]string, 0, len(v))}
+
 	for _, e := range v {
-		if s, ok := e.(string); ok {
-			o.values = append(o.values, s)
-			continue
+		s, p, ok, err := textOrPETFromValue(e)
+		if !ok {
+			return fmt.Errorf("unknow type for item: %T", e)
+		}
+		if err != nil {
+			return err
 		}
-		return fmt.Errorf("unknow type for item: %T", e)
+		values.appendValue(s, p)
 	}
+
+	o.appendFrom(values, 0)
+
 	return nil
 }
 
+// toCBOR returns the items encoded according to ver, or nil if there are none
+func (o Items) toCBOR(ver Version) (interface{}, error) {
+	if !o.IsSet() {
+		return nil, nil
+	}
+
+	items := make([]interface{}, 0, len(o.values))
+
+	for i, v := range o.values {
+		var (
+			item interface{}
+			err  error
+		)
+		if p := o.pet(i); p != nil {
+			item, err = p.toCBOR(ver)
+		} else {
+			item, err = textOrPETToCBOR(v, ver)
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
 func (o *Items) Append(v []string) {
 	o.values = append(o.values, v...)
+	if o.pets != nil {
+		o.pets = append(o.pets, make([]pet, len(v))...)
+	}
+}
+
+// appendValue appends s, and the elements of the PET it was read as, if any
+func (o *Items) appendValue(s string, p pet) {
+	if p != nil && o.pets == nil {
+		o.pets = make([]pet, len(o.values), cap(o.values))
+	}
+	o.values = append(o.values, s)
+	if o.pets != nil {
+		o.pets = append(o.pets, p)
+	}
 }
 
+// appendFrom appends the items of src from the k-th one on, keeping their PET
+// elements
+func (o *Items) appendFrom(src Items, k int) {
+	for i := k; i < len(src.values); i++ {
+		o.appendValue(src.values[i], src.pet(i))
+	}
+}
+
+// TrimN removes the last n items, or all of them if there are no more than n
 func (o *Items) TrimN(n uint64) {
-	if o.Count() < n || n > math.MaxInt {
+	if n >= o.Count() {
+		o.values = o.values[:0]
+		o.pets = nil
 		return
 	}
 
 	o.values = o.values[:len(o.values)-int(n)]
+	if o.pets != nil {
+		o.pets = o.pets[:len(o.values)]
+	}
 }
@@ -0,0 +1,130 @@
+package href
+
+import (
+	"bytes"
+	"net"
+	"strings"
+)
+
+var defaultPorts = map[string]uint64{
+	"coap":      5683,
+	"coaps":     5684,
+	"coap+tcp":  5683,
+	"coaps+tcp": 5684,
+	"coap+ws":   80,
+	"coaps+ws":  443,
+	"http":      80,
+	"https":     443,
+}
+
+// Normalize rewrites the CRI reference in place into a normal form, so that
+// equivalent CRI references have the same abstract form:
+//
+//   - a scheme-name that has a scheme-id is replaced by the scheme-id;
+//   - host-names are lowercased;
+//   - an IPv4-mapped IPv6 host-ip is replaced by the IPv4 address;
+//   - a port that is the default for the scheme is removed;
+//   - empty path and query are unset.
+//
+// The empty array ([]) and [0] already have the same abstract form.
+func (o *CRI) Normalize() {
+	if name, ok := o.Scheme.Get().(string); ok {
+		if id, ok := LookupSchemeID(name); ok {
+			_ = o.Scheme.Set(id)
+		}
+	}
+
+	o.Authority.Host.normalize()
+
+	if p, ok := defaultPorts[o.Scheme.String()]; ok && o.Authority.Port.IsSet() && o.Authority.Port.Get() == p {
+		o.Authority.Port = Port{}
+	}
+
+	if !o.Path.IsSet() {
+		o.Path = Path{}
+	}
+
+	if !o.Query.IsSet() {
+		o.Query = Query{}
+	}
+}
+
+func (o *Host) normalize() {
+	switch t := o.val.(type) {
+	case string:
+		o.val = strings.ToLower(t)
+	case []string:
+		labels := make([]string, 0, len(t))
+		for _, l := range t {
+			labels = append(labels, strings.ToLower(l))
+		}
+		o.val = labels
+	case net.IP:
+		if ip4 := t.To4(); ip4 != nil && len(t) == net.IPv6len && !o.HasZone() {
+			o.val = ip4
+		}
+	}
+}
+
+// Equal tells whether a and b identify the same resource, i.e., whether they
+// are the same once normalized (see Normalize).  Path, query and fragment are
+// compared in their percent-encoded form, where the byte string elements of a
+// PET are always percent-encoded.
+func Equal(a, b *CRI) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	na, nb := a.Clone(), b.Clone()
+	na.Normalize()
+	nb.Normalize()
+
+	return na.Discard.Get() == nb.Discard.Get() &&
+		na.Scheme.Get() == nb.Scheme.Get() &&
+		na.Authority.equal(nb.Authority) &&
+		equalStrings(na.Path.escapedValues(isPathChar), nb.Path.escapedValues(isPathChar)) &&
+		equalStrings(na.Query.escapedValues(isQueryItemChar), nb.Query.escapedValues(isQueryItemChar)) &&
+		na.Fragment.IsSet() == nb.Fragment.IsSet() &&
+		na.Fragment.String() == nb.Fragment.String()
+}
+
+func (o Authority) equal(other Authority) bool {
+	return o.IsNull == other.IsNull &&
+		o.IsTrue == other.IsTrue &&
+		o.Userinfo.IsSet() == other.Userinfo.IsSet() &&
+		o.Userinfo.Get() == other.Userinfo.Get() &&
+		o.Host.equal(other.Host) &&
+		o.Port.IsSet() == other.Port.IsSet() &&
+		o.Port.Get() == other.Port.Get()
+}
+
+func (o Host) equal(other Host) bool {
+	if o.zone != other.zone {
+		return false
+	}
+
+	switch t := o.val.(type) {
+	case net.IP:
+		ip, ok := other.val.(net.IP)
+		return ok && bytes.Equal(t, ip)
+	case nil:
+		return other.val == nil
+	default:
+		// host-names compare by their labels, whatever their form
+		return other.Labels() != nil && equalStrings(o.Labels(), other.Labels())
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
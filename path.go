@@ -1,6 +1,6 @@
 package href
 
-// path = [*text]
+// path = [*text-or-pet]
 type Path struct {
 	Items
 }
@@ -21,6 +21,10 @@ func (o Path) GetSegments() []string {
 	return o.Items.GetValues()
 }
 
+func (o Path) clone() Path {
+	return Path{o.Items.clone()}
+}
+
 func (o *Path) Reset() {
 	o.Items.Reset()
 }
@@ -0,0 +1,152 @@
+package href
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+text-or-pet = text / pet
+pet         = [+(text / bytes)]
+
+A percent-encoded text (PET) carries content that is not valid UTF-8: the text
+elements hold the UTF-8 parts and the byte string elements hold the rest,
+which is percent-encoded when converting to a URI.  The value of a PET is the
+concatenation of its elements.  A PET that is read is kept as such (see pet),
+while a value that is set as a Go string is split by textOrPETToCBOR.
+*/
+
+// textOrPETToCBOR returns s as a text string if it is valid UTF-8, otherwise as
+// a PET that alternates the valid UTF-8 runs (text) with the invalid ones
+// (bytes)
+func textOrPETToCBOR(s string, ver Version) (interface{}, error) {
+	if utf8.ValidString(s) {
+		return s, nil
+	}
+
+	if ver == Href09 {
+		return nil, fmt.Errorf("invalid UTF-8 %q: percent-encoded text not supported in %s", s, ver)
+	}
+
+	var (
+		pet   []interface{}
+		start int
+		bytes bool
+	)
+
+	flush := func(end int) {
+		if end == start {
+			return
+		}
+		if bytes {
+			pet = append(pet, []byte(s[start:end]))
+		} else {
+			pet = append(pet, s[start:end])
+		}
+		start = end
+	}
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		invalid := r == utf8.RuneError && size == 1
+		if invalid != bytes {
+			flush(i)
+			bytes = invalid
+		}
+		i += size
+	}
+	flush(len(s))
+
+	return pet, nil
+}
+
+// pet holds the elements of a PET as they were set, so that a byte string
+// element is percent-encoded even when it happens to be valid UTF-8 (e.g.
+// h'3d26' is "%3D%26", not "=&")
+type pet []petPart
+
+type petPart struct {
+	s     string
+	bytes bool
+}
+
+// String returns the value of the PET, i.e., the concatenation of its elements
+func (o pet) String() string {
+	var b strings.Builder
+	for _, p := range o {
+		b.WriteString(p.s)
+	}
+	return b.String()
+}
+
+// pctEncode percent-encodes the text elements according to allowed and every
+// byte of the byte string elements
+func (o pet) pctEncode(allowed func(byte) bool) string {
+	var b strings.Builder
+	for _, p := range o {
+		if p.bytes {
+			b.WriteString(pctEncode(p.s, func(byte) bool { return false }))
+		} else {
+			b.WriteString(pctEncode(p.s, allowed))
+		}
+	}
+	return b.String()
+}
+
+// toCBOR returns the PET with its elements as they were set
+func (o pet) toCBOR(ver Version) (interface{}, error) {
+	if ver == Href09 {
+		return nil, fmt.Errorf("percent-encoded text %q not supported in %s", o.String(), ver)
+	}
+
+	elements := make([]interface{}, 0, len(o))
+	for _, p := range o {
+		if p.bytes {
+			elements = append(elements, []byte(p.s))
+		} else {
+			elements = append(elements, p.s)
+		}
+	}
+
+	return elements, nil
+}
+
+// textOrPETFromValue returns the value of a text or a PET given in its decoded
+// form: a string, taken as is even if it is not valid UTF-8, or the elements of
+// a PET, strings (valid UTF-8) and []byte.  The elements of a PET are returned
+// too.
+func textOrPETFromValue(v interface{}) (string, pet, bool, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil, true, nil
+	case []interface{}:
+		if len(t) == 0 {
+			return "", nil, true, fmt.Errorf("percent-encoded text cannot be empty")
+		}
+
+		var (
+			b strings.Builder
+			p = make(pet, 0, len(t))
+		)
+
+		for _, e := range t {
+			switch et := e.(type) {
+			case string:
+				if !utf8.ValidString(et) {
+					return "", nil, true, fmt.Errorf("invalid UTF-8 in percent-encoded text element %q", et)
+				}
+				p = append(p, petPart{s: et})
+			case []byte:
+				p = append(p, petPart{s: string(et), bytes: true})
+			default:
+				return "", nil, true, fmt.Errorf("unknown type for percent-encoded text element: %T", e)
+			}
+			b.WriteString(p[len(p)-1].s)
+		}
+
+		return b.String(), p, true, nil
+	}
+
+	return "", nil, false, nil
+}
@@ -1,12 +1,16 @@
 package href
 
-// query = [*text]
+import "strings"
+
+// query = [*text-or-pet]
 type Query struct {
 	Items
 }
 
+// String returns the URI query component: the query items are
+// percent-encoded and joined with "&"
 func (o Query) String() string {
-	return o.Items.String("&")
+	return strings.Join(o.Items.escapedValues(isQueryItemChar), "&")
 }
 
 func (o Query) IsSet() bool {
@@ -17,6 +21,10 @@ func (o Query) Get() interface{} {
 	return o.Items.Get()
 }
 
+func (o Query) clone() Query {
+	return Query{o.Items.clone()}
+}
+
 func (o *Query) Reset() {
 	o.Items.Reset()
 }
@@ -0,0 +1,137 @@
+package href
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// RelativeTo computes the shortest CRI reference that resolves to o when
+// resolved against base, i.e., the inverse of ResolveReference.  Both o and
+// base must be absolute CRIs.  If no relative reference is shorter, a copy of o
+// is returned.
+//
+// The candidates are: a relative reference with the smallest discard that
+// keeps the common path prefix, the other (larger) discards, an absolute-path
+// reference (discard true), a network-path reference and o itself.  Each
+// candidate is resolved against base and the shortest one that yields o is
+// chosen.
+func (o *CRI) RelativeTo(base *CRI) (*CRI, error) {
+	if !base.IsAbs() {
+		return nil, ErrNotAbsoluteBase
+	}
+
+	if err := o.Validate(); err != nil {
+		return nil, fmt.Errorf("target: %w", err)
+	}
+
+	if !o.IsAbs() {
+		return nil, fmt.Errorf("target is not an absolute CRI")
+	}
+
+	target, err := o.ToCBOR()
+	if err != nil {
+		return nil, err
+	}
+
+	best := o.Clone()
+	bestLen := len(target)
+
+	for _, candidate := range o.relativeCandidates(base) {
+		// e.g., a discard of 0 followed by a path
+		if candidate.Validate() != nil {
+			continue
+		}
+
+		resolved, err := base.ResolveReference(candidate)
+		if err != nil {
+			return nil, err
+		}
+
+		got, err := resolved.ToCBOR()
+		if err != nil || !bytes.Equal(got, target) {
+			continue
+		}
+
+		encoded, err := candidate.ToCBOR()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(encoded) < bestLen {
+			best, bestLen = candidate, len(encoded)
+		}
+	}
+
+	return best, nil
+}
+
+// relativeCandidates returns the relative references that may resolve to o
+// against base, ordered from the most to the least preferred
+func (o *CRI) relativeCandidates(base *CRI) []*CRI {
+	var candidates []*CRI
+
+	if o.Scheme.String() != base.Scheme.String() {
+		return nil
+	}
+
+	if base.Authority.String() == o.Authority.String() &&
+		base.Authority.IsNull == o.Authority.IsNull {
+		// discard = n, keeping the first k segments of the base path
+		var (
+			basePath   = base.Path.values
+			targetPath = o.Path.values
+			k          = 0
+		)
+
+		for k < len(basePath) && k < len(targetPath) && basePath[k] == targetPath[k] {
+			k++
+		}
+
+		for ; k >= 0; k-- {
+			n := uint64(len(basePath) - k)
+			if n > 127 {
+				break
+			}
+
+			c := o.localPart()
+			_ = c.Discard.Set(n)
+			c.Path.Reset()
+			c.Path.appendFrom(o.Path.Items, k)
+
+			// query and fragment may be inherited from the base
+			if n == 0 && !c.Path.IsSet() {
+				c1 := c.Clone()
+				c1.Query.Reset()
+				c2 := c1.Clone()
+				c2.Fragment.Reset()
+				candidates = append(candidates, c2, c1)
+			}
+
+			candidates = append(candidates, c)
+		}
+	}
+
+	// discard = true
+	c := o.localPart()
+	_ = c.Discard.Set(true)
+	candidates = append(candidates, c)
+
+	// network-path reference
+	if o.Authority.IsSet() {
+		c = o.localPart()
+		c.Authority = o.Authority.clone()
+		candidates = append(candidates, c)
+	}
+
+	return candidates
+}
+
+// localPart returns a CRI reference with a copy of the path, query and fragment
+// of o
+func (o *CRI) localPart() *CRI {
+	return &CRI{
+		Path:     o.Path.clone(),
+		Query:    o.Query.clone(),
+		Fragment: o.Fragment.clone(),
+	}
+}
@@ -3,6 +3,7 @@ package href
 import (
 	"fmt"
 	"regexp"
+	"sync"
 )
 
 type Scheme struct {
@@ -14,18 +15,110 @@ const (
 )
 
 var (
-	schemeRE = regexp.MustCompile(schemeREString)
-
-	schemeIDtoString = map[int64]string{
-		-1: "coap",
-		-2: "coaps",
-		-3: "http",
-		-4: "https",
-		-5: "urn",
-		-6: "did",
+	schemeRE = regexp.MustCompile(`^` + schemeREString + `$`)
+
+	// defaultSchemes are the initial entries of the CRI Scheme Numbers
+	// registry, based on the IANA CoAP Proxy-Scheme registry, mapped to
+	// scheme-ids as per the draft (scheme-id = -1 - scheme-number)
+	defaultSchemes = map[int64]string{
+		-1:  "coap",
+		-2:  "coaps",
+		-3:  "http",
+		-4:  "https",
+		-5:  "urn",
+		-6:  "did",
+		-7:  "coap+tcp",
+		-8:  "coaps+tcp",
+		-25: "coap+ws",
+		-26: "coaps+ws",
 	}
+
+	// schemes is the registry of scheme-ids, initialized with defaultSchemes
+	schemes = newSchemeRegistry(defaultSchemes)
 )
 
+// schemeRegistry is a bidirectional mapping between scheme-ids and
+// scheme-names, safe for concurrent use
+type schemeRegistry struct {
+	mu     sync.RWMutex
+	byID   map[int64]string
+	byName map[string]int64
+}
+
+func newSchemeRegistry(entries map[int64]string) *schemeRegistry {
+	r := &schemeRegistry{
+		byID:   make(map[int64]string, len(entries)),
+		byName: make(map[string]int64, len(entries)),
+	}
+
+	for id, name := range entries {
+		r.byID[id] = name
+		r.byName[name] = id
+	}
+
+	return r
+}
+
+func (o *schemeRegistry) register(id int64, name string) error {
+	if id >= 0 {
+		return fmt.Errorf("scheme-id must be nint, got %d", id)
+	}
+
+	if !schemeRE.MatchString(name) {
+		return fmt.Errorf("scheme-name %s does not match scheme RE (%s)", name, schemeREString)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if s, ok := o.byID[id]; ok && s != name {
+		return fmt.Errorf("scheme-id %d already registered for %s", id, s)
+	}
+
+	if i, ok := o.byName[name]; ok && i != id {
+		return fmt.Errorf("scheme-name %s already registered with scheme-id %d", name, i)
+	}
+
+	o.byID[id] = name
+	o.byName[name] = id
+
+	return nil
+}
+
+func (o *schemeRegistry) name(id int64) (string, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	s, ok := o.byID[id]
+	return s, ok
+}
+
+func (o *schemeRegistry) id(name string) (int64, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	i, ok := o.byName[name]
+	return i, ok
+}
+
+// RegisterScheme adds the mapping between the scheme-id id and the scheme-name
+// name to the registry used by all CRI operations.  Registering an existing
+// mapping again is a no-op; remapping an already registered id or name is an
+// error.  RegisterScheme is safe for concurrent use.
+func RegisterScheme(id int64, name string) error {
+	return schemes.register(id, name)
+}
+
+// LookupSchemeName returns the scheme-name registered for the scheme-id id
+func LookupSchemeName(id int64) (string, bool) {
+	return schemes.name(id)
+}
+
+// LookupSchemeID returns the scheme-id registered for the scheme-name name
+func LookupSchemeID(name string) (int64, bool) {
+	return schemes.id(name)
+}
+
 func (o Scheme) IsSet() bool {
 	return o.val != nil
 }
@@ -34,23 +127,82 @@ func (o Scheme) Get() interface{} {
 	return o.val
 }
 
+// ID returns the scheme-id, if the scheme is set as such
+func (o Scheme) ID() (int64, bool) {
+	id, ok := o.val.(int64)
+	return id, ok
+}
+
+// Name returns the scheme-name.  A scheme-id is mapped to its scheme-name, if
+// it is known.
+func (o Scheme) Name() (string, bool) {
+	switch t := o.val.(type) {
+	case string:
+		return t, true
+	case int64:
+		return LookupSchemeName(t)
+	}
+	return "", false
+}
+
+// SetName sets the scheme-name
+func (o *Scheme) SetName(name string) error {
+	if !schemeRE.MatchString(name) {
+		return fmt.Errorf("scheme-name %s does not match scheme RE (%s)", name, schemeREString)
+	}
+	o.val = name
+	return nil
+}
+
+// SetID sets the scheme-id, which must be negative
+func (o *Scheme) SetID(id int64) error {
+	if id >= 0 {
+		return fmt.Errorf("scheme-id must be nint, got %d", id)
+	}
+	o.val = id
+	return nil
+}
+
 func (o *Scheme) Set(v interface{}) error {
 	switch t := v.(type) {
 	case string:
 		// scheme-name
+		return o.SetName(t)
+	case int64:
+		// scheme-id
+		return o.SetID(t)
+	case nil:
+		// no scheme
+		o.val = nil
+	default:
+		return fmt.Errorf("unknown scheme type: %T", t)
+	}
+
+	return nil
+}
+
+// toCBOR returns the scheme as encoded in the CRI array.  If preferID is
+// true, a registered scheme-name is replaced by its scheme-id.
+func (o Scheme) toCBOR(preferID bool) interface{} {
+	if name, ok := o.val.(string); ok && preferID {
+		if id, ok := LookupSchemeID(name); ok {
+			return id
+		}
+	}
+	return o.val
+}
+
+func (o Scheme) validate() error {
+	switch t := o.val.(type) {
+	case string:
 		if !schemeRE.MatchString(t) {
 			return fmt.Errorf("scheme-name %s does not match scheme RE (%s)", t, schemeREString)
 		}
-		o.val = t
 	case int64:
-		// scheme-id
 		if t >= 0 {
 			return fmt.Errorf("scheme-id must be nint, got %d", t)
 		}
-		o.val = t
 	case nil:
-		// no scheme
-		o.val = nil
 	default:
 		return fmt.Errorf("unknown scheme type: %T", t)
 	}
@@ -59,7 +211,7 @@ func (o *Scheme) Set(v interface{}) error {
 }
 
 func SchemeIDtoString(schemeID int64) string {
-	s, ok := schemeIDtoString[schemeID]
+	s, ok := LookupSchemeName(schemeID)
 	if !ok {
 		return fmt.Sprintf("scheme-id(%d)", schemeID)
 	}
@@ -78,15 +230,3 @@ func (o Scheme) String() string {
 
 	return ""
 }
-
-func isScheme(e interface{}) bool {
-	switch t := e.(type) {
-	case string: // scheme-name
-		return true
-	case int64: // scheme-id is nint
-		return t <= 0
-	case nil: // no scheme
-		return true
-	}
-	return false
-}
@@ -0,0 +1,38 @@
+#!/bin/bash
+#
+# Runs the Parse benchmarks of bench_test.go against a baseline revision and
+# against the working tree, e.g. the revision before a change to the decoder.
+#
+# Usage: scripts/bench-parse.sh <baseline-revision>
+#
+# Set COUNT to change the number of runs (default 3).  The outputs can be
+# compared with benchstat.
+
+set -euo pipefail
+
+if [ $# -ne 1 ]; then
+	echo "usage: $0 <baseline-revision>" >&2
+	exit 2
+fi
+
+BASE=$1
+COUNT=${COUNT:-3}
+BENCH_ARGS=(-run '^$' -bench Parse -benchmem -count "${COUNT}")
+
+top=$(git rev-parse --show-toplevel)
+work=$(mktemp -d)
+
+trap 'git -C "${top}" worktree remove --force "${work}/base"; rm -rf "${work}"' EXIT
+
+git -C "${top}" worktree add --detach -q "${work}/base" "${BASE}"
+cp "${top}/bench_test.go" "${work}/base/"
+
+echo ">>> ${BASE}"
+(cd "${work}/base" && go test "${BENCH_ARGS[@]}") | tee "${work}/old.txt"
+
+echo ">>> working tree"
+(cd "${top}" && go test "${BENCH_ARGS[@]}") | tee "${work}/new.txt"
+
+if command -v benchstat > /dev/null; then
+	benchstat "${work}/old.txt" "${work}/new.txt"
+fi
@@ -0,0 +1,245 @@
+package href
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// maxNesting bounds the nesting of arrays, maps and tags in a data item read
+// by a Decoder.  CRI references nest at most 4 levels deep.
+const maxNesting = 16
+
+// DecodeError is returned by Decoder.Decode when an item of the CBOR sequence
+// cannot be decoded.  Offset is the position of the item in the input stream.
+type DecodeError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("item at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder reads CRI references from a CBOR sequence (RFC 8742)
+type Decoder struct {
+	r    *bufio.Reader
+	opts []Option
+
+	// offset is the number of bytes consumed from r
+	offset int64
+	// itemOffset is the offset of the last item read
+	itemOffset int64
+
+	buf bytes.Buffer
+	// err is the error that stops decoding
+	err error
+}
+
+// NewDecoder returns a Decoder that reads from r.  The options are passed to
+// Parse for each item.
+func NewDecoder(r io.Reader, opts ...Option) *Decoder {
+	return &Decoder{
+		r:    bufio.NewReader(r),
+		opts: opts,
+	}
+}
+
+// Offset returns the position in the input stream of the item last returned
+// (or failed) by Decode
+func (d *Decoder) Offset() int64 {
+	return d.itemOffset
+}
+
+// Decode reads the next item of the CBOR sequence and parses it as a CRI
+// reference.  At the end of the sequence, it returns io.EOF.  Errors are
+// returned as *DecodeError.  If an item is well-formed CBOR but not a valid CRI
+// reference, decoding can continue with the next item; otherwise, the error is
+// returned again on subsequent calls.
+func (d *Decoder) Decode() (*CRI, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	d.buf.Reset()
+	d.itemOffset = d.offset
+
+	if _, err := d.r.Peek(1); err == io.EOF {
+		return nil, io.EOF
+	}
+
+	if err := d.readItem(0); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = &DecodeError{Offset: d.itemOffset, Err: err}
+		return nil, d.err
+	}
+
+	cri, err := Parse(d.buf.Bytes(), d.opts...)
+	if err != nil {
+		return nil, &DecodeError{Offset: d.itemOffset, Err: err}
+	}
+
+	return cri, nil
+}
+
+func (d *Decoder) read(n uint64) error {
+	if n > math.MaxInt64 {
+		return errors.New("data item too long")
+	}
+
+	copied, err := io.CopyN(&d.buf, d.r, int64(n))
+	d.offset += copied
+
+	return err
+}
+
+// readHead reads the head of the next data item into the buffer
+func (d *Decoder) readHead() (cborHead, error) {
+	start := d.buf.Len()
+
+	if err := d.read(1); err != nil {
+		return cborHead{}, err
+	}
+
+	switch ai := d.buf.Bytes()[start] & 0x1f; ai {
+	case aiUint8:
+		if err := d.read(1); err != nil {
+			return cborHead{}, err
+		}
+	case aiUint16:
+		if err := d.read(2); err != nil {
+			return cborHead{}, err
+		}
+	case aiUint32:
+		if err := d.read(4); err != nil {
+			return cborHead{}, err
+		}
+	case aiUint64:
+		if err := d.read(8); err != nil {
+			return cborHead{}, err
+		}
+	}
+
+	return readHead(d.buf.Bytes()[start:])
+}
+
+// readBreak consumes the "break" stop code, if it is the next byte
+func (d *Decoder) readBreak() (bool, error) {
+	b, err := d.r.Peek(1)
+	if err != nil {
+		return false, err
+	}
+
+	if b[0] != 0xff {
+		return false, nil
+	}
+
+	return true, d.read(1)
+}
+
+// readItem reads the next data item into the buffer
+func (d *Decoder) readItem(depth int) error {
+	if depth > maxNesting {
+		return errors.New("data item nested too deeply")
+	}
+
+	h, err := d.readHead()
+	if err != nil {
+		return err
+	}
+
+	var items uint64
+
+	switch h.major {
+	case majorUint, majorNint:
+		return nil
+	case majorBytes, majorText:
+		if !h.isIndefinite() {
+			return d.read(h.arg)
+		}
+		// chunks, until break
+		for {
+			stop, err := d.readBreak()
+			if err != nil || stop {
+				return err
+			}
+			chunk, err := d.readHead()
+			if err != nil {
+				return err
+			}
+			if chunk.major != h.major || chunk.isIndefinite() {
+				return errors.New("malformed CBOR: bad chunk in indefinite-length string")
+			}
+			if err := d.read(chunk.arg); err != nil {
+				return err
+			}
+		}
+	case majorArray:
+		items = h.arg
+	case majorMap:
+		if h.arg > math.MaxUint64/2 {
+			return errors.New("data item too long")
+		}
+		items = 2 * h.arg
+	case majorTag:
+		return d.readItem(depth + 1)
+	case majorSimple:
+		if h.isIndefinite() {
+			return errors.New("malformed CBOR: unexpected break")
+		}
+		return nil
+	}
+
+	if h.isIndefinite() {
+		for {
+			stop, err := d.readBreak()
+			if err != nil || stop {
+				return err
+			}
+			if err := d.readItem(depth + 1); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := uint64(0); i < items; i++ {
+		if err := d.readItem(depth + 1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Encoder writes CRI references as a CBOR sequence (RFC 8742)
+type Encoder struct {
+	w    io.Writer
+	opts []Option
+}
+
+// NewEncoder returns an Encoder that writes to w.  The options are passed to
+// ToCBOR for each CRI reference.
+func NewEncoder(w io.Writer, opts ...Option) *Encoder {
+	return &Encoder{w: w, opts: opts}
+}
+
+// Encode writes the transfer form of the CRI reference to the stream
+func (e *Encoder) Encode(cri *CRI) error {
+	data, err := cri.ToCBOR(e.opts...)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(data)
+
+	return err
+}
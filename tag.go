@@ -0,0 +1,62 @@
+package href
+
+import (
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CBOR tags of a CRI reference and of a URI reference (text string)
+const (
+	TagCRI = 99
+	TagURI = 32
+)
+
+// untag splits a tagged CBOR data item into its tag number and content.  If
+// the data item is not tagged, tagged is false and content is raw.
+func untag(raw []byte) (tag uint64, content []byte, tagged bool, err error) {
+	// major type 6: tag
+	if len(raw) == 0 || raw[0]>>5 != 6 {
+		return 0, raw, false, nil
+	}
+
+	var rt cbor.RawTag
+
+	if err := cbor.Unmarshal(raw, &rt); err != nil {
+		return 0, nil, false, err
+	}
+
+	return rt.Number, rt.Content, true, nil
+}
+
+// ParseURITag decodes a URI reference wrapped in CBOR tag 32 (RFC 8949, §3.4.5.3)
+// and converts it into a CRI reference using FromURI
+func ParseURITag(data []byte) (*CRI, error) {
+	tag, content, tagged, err := untag(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !tagged || tag != TagURI {
+		return nil, fmt.Errorf("expecting tag %d", TagURI)
+	}
+
+	var uri string
+
+	if err := cbor.Unmarshal(content, &uri); err != nil {
+		return nil, fmt.Errorf("tag %d content: %w", TagURI, err)
+	}
+
+	return FromURI(uri)
+}
+
+// ToURITag converts the CRI reference to a URI reference using ToURI and
+// encodes it as a text string wrapped in CBOR tag 32
+func (o *CRI) ToURITag() ([]byte, error) {
+	u, err := o.ToURI()
+	if err != nil {
+		return nil, err
+	}
+
+	return cbor.Marshal(cbor.Tag{Number: TagURI, Content: u.String()})
+}
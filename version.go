@@ -0,0 +1,96 @@
+package href
+
+import "fmt"
+
+// Version identifies the revision of draft-ietf-core-href whose wire format
+// rules are applied by Parse and ToCBOR.  The abstract form of a CRI is the
+// same for all versions.
+type Version int
+
+const (
+	// Href09 is draft-ietf-core-href-09: the authority is [host, ?port] and a
+	// host-name is a single text string, with labels separated by dots.
+	Href09 Version = 9
+	// Href16 is draft-ietf-core-href-16: the authority is [?userinfo, host,
+	// ?port], a host-name is a sequence of text labels (a label may contain
+	// a dot), an IPv6 host-ip may be followed by a zone-id, and path, query
+	// and fragment items may be percent-encoded text (PET).
+	Href16 Version = 16
+
+	// LatestVersion is the version used when none is selected
+	LatestVersion = Href16
+)
+
+func (o Version) String() string {
+	return fmt.Sprintf("href-%02d", int(o))
+}
+
+func (o Version) isValid() bool {
+	return o == Href09 || o == Href16
+}
+
+// Option configures Parse and ToCBOR
+type Option func(*options)
+
+type options struct {
+	version  Version
+	schemeID bool
+	compact  bool
+	tagged   bool
+	strict   bool
+}
+
+// WithVersion selects the draft version whose wire format rules apply
+func WithVersion(v Version) Option {
+	return func(o *options) {
+		o.version = v
+	}
+}
+
+// WithSchemeID makes ToCBOR emit the scheme-id in place of a scheme-name that
+// is in the scheme registry (see RegisterScheme)
+func WithSchemeID() Option {
+	return func(o *options) {
+		o.schemeID = true
+	}
+}
+
+// WithCompact makes ToCBOR emit the smallest equivalent encoding of the CRI
+// reference (see Compact), without modifying it
+func WithCompact() Option {
+	return func(o *options) {
+		o.compact = true
+	}
+}
+
+// WithTag makes ToCBOR wrap the CRI array in the CRI tag (99)
+func WithTag() Option {
+	return func(o *options) {
+		o.tagged = true
+	}
+}
+
+// WithStrict makes Parse reject transfer forms that are not in core
+// deterministic encoding (RFC 8949, §4.2.1), e.g., with indefinite lengths,
+// integers or floats not in their shortest form, or trailing bytes
+func WithStrict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+func newOptions(opts []Option) (*options, error) {
+	o := &options{
+		version: LatestVersion,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if !o.version.isValid() {
+		return nil, fmt.Errorf("unsupported version: %s", o.version)
+	}
+
+	return o, nil
+}